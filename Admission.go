@@ -0,0 +1,155 @@
+package mpserver
+
+import (
+    "math"
+    "net/http"
+    "strconv"
+    "sync/atomic"
+    "time"
+)
+
+// AdmissionPolicy specifies when a handler should reject a 
+// request instead of waiting for the pipeline to accept its job.
+// Zero values mean that the corresponding limit is not enforced.
+type AdmissionPolicy struct {
+    // MaxWait is the maximum time a request waits for the 
+    // pipeline to accept its job.
+    MaxWait time.Duration
+
+    // MaxInFlight is the maximum number of jobs that can be in 
+    // the pipeline at the same time.
+    MaxInFlight int
+
+    // QueueLength is the maximum number of requests that can be 
+    // waiting for the pipeline to accept their jobs.
+    QueueLength int
+
+    // RetryAfter is the time sent in the Retry-After header of 
+    // rejected requests. It is rounded up to whole seconds and 
+    // defaults to one second.
+    RetryAfter time.Duration
+}
+
+// Admission enforces an AdmissionPolicy for one or more handlers
+// and counts the admitted and rejected requests. It is safe for
+// concurrent use.
+type Admission struct {
+    policy AdmissionPolicy
+    slots chan bool // Jobs in flight, nil if unlimited.
+
+    waiting int64
+    admitted uint64
+    rejected uint64
+}
+
+// NewAdmission returns an Admission object that enforces the 
+// provided policy. It should be passed to handlers using the
+// WithAdmission option.
+func NewAdmission(policy AdmissionPolicy) *Admission {
+    a := &Admission{policy: policy}
+    if (policy.MaxInFlight > 0) {
+        a.slots = make(chan bool, policy.MaxInFlight)
+    }
+    return a
+}
+
+// Admitted returns the number of requests, whose jobs were 
+// accepted by the pipeline.
+func (a *Admission) Admitted() uint64 {
+    return atomic.LoadUint64(&a.admitted)
+}
+
+// Rejected returns the number of requests that were rejected 
+// with the 503 response code.
+func (a *Admission) Rejected() uint64 {
+    return atomic.LoadUint64(&a.rejected)
+}
+
+// Waiting returns the number of requests that are currently
+// waiting for the pipeline to accept their jobs.
+func (a *Admission) Waiting() int {
+    return int(atomic.LoadInt64(&a.waiting))
+}
+
+// InFlight returns the number of jobs that are currently in the
+// pipeline. It is always zero if MaxInFlight isn't set.
+func (a *Admission) InFlight() int {
+    return len(a.slots)
+}
+
+// reject writes the 503 response with the Retry-After header to
+// the client of the provided job.
+func (a *Admission) reject(job Job) {
+    atomic.AddUint64(&a.rejected, 1)
+    retryAfter := a.policy.RetryAfter
+    if (retryAfter <= 0) {
+        retryAfter = time.Second
+    }
+    w := job.getResponseWriter()
+    w.Header().Set("Retry-After", 
+        strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+    http.Error(w, "Server is too busy.", 
+        http.StatusServiceUnavailable)
+}
+
+// admit tries to send the job to the output channel according to
+// the policy. If the job can't be sent in time the request is 
+// rejected. It returns true if the job was sent, in which case 
+// release must be called once the handler stops waiting for it.
+func (a *Admission) admit(job Job, out chan<- Job) bool {
+    waiting := atomic.AddInt64(&a.waiting, 1)
+    defer atomic.AddInt64(&a.waiting, -1)
+    if (a.policy.QueueLength > 0 && 
+        waiting > int64(a.policy.QueueLength)) {
+        a.reject(job)
+        return false
+    }
+
+    var timeout <-chan time.Time
+    if (a.policy.MaxWait > 0) {
+        timer := time.NewTimer(a.policy.MaxWait)
+        defer timer.Stop()
+        timeout = timer.C
+    }
+
+    // Wait for a free slot in the pipeline.
+    if (a.slots != nil) {
+        select {
+            case a.slots <- true: {}
+            case <- timeout: { a.reject(job); return false }
+            case <- job.Context().Done(): return false
+        }
+    }
+
+    // Wait for the pipeline to accept the job.
+    select {
+        case out <- job: {
+            atomic.AddUint64(&a.admitted, 1)
+            return true
+        }
+        case <- timeout: a.reject(job)
+        case <- job.Context().Done(): {}
+    }
+    a.release()
+    return false
+}
+
+// release frees the slot held by a job. It is called once the 
+// handler stops waiting for the job, because it was completed, 
+// abandoned after the deadline or the client went away. A job 
+// that a pipeline drops or keeps forever therefore only holds its
+// slot until the handler gives up on it, so pipelines that lose 
+// jobs should be used with a deadline.
+func (a *Admission) release() {
+    if (a.slots != nil) {
+        <- a.slots
+    }
+}
+
+// WithAdmission returns a HandlerOption that makes the handler 
+// enforce the policy of the provided Admission object.
+func WithAdmission(a *Admission) HandlerOption {
+    return func (config *handlerConfig) {
+        config.admission = a
+    }
+}
@@ -0,0 +1,126 @@
+package mpserver_test
+
+import (
+    "context"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+    "mpserver"
+)
+
+// serveAsync serves a GET request with the handler in a new
+// goroutine and returns the recorder and a channel that is
+// closed when the handler returns.
+func serveAsync(h http.Handler,
+                ctx context.Context) (*httptest.ResponseRecorder,
+                                      chan struct{}) {
+    rec := httptest.NewRecorder()
+    r := httptest.NewRequest("GET", "/", nil).WithContext(ctx)
+    done := make(chan struct{})
+    go func () {
+        defer close(done)
+        h.ServeHTTP(rec, r)
+    }()
+    return rec, done
+}
+
+func TestAdmissionRejects(t *testing.T) {
+    a := mpserver.NewAdmission(mpserver.AdmissionPolicy{
+        MaxInFlight: 1,
+        MaxWait: 10*time.Millisecond,
+        RetryAfter: 1500*time.Millisecond,
+    })
+    out := mpserver.GetChan()
+    h := mpserver.Handler(out, mpserver.WithAdmission(a))
+
+    // The first job holds the only slot.
+    first, firstDone := serveAsync(h, context.Background())
+    job := <- out
+
+    rec, done := serveAsync(h, context.Background())
+    <- done
+    if (rec.Code != http.StatusServiceUnavailable) {
+        t.Errorf("Response code is %d, want 503.", rec.Code)
+    }
+    if (rec.Header().Get("Retry-After") != "2") {
+        t.Errorf("Retry-After is %q, want 2.",
+                 rec.Header().Get("Retry-After"))
+    }
+    if (a.Admitted() != 1 || a.Rejected() != 1 || a.InFlight() != 1) {
+        t.Errorf("Admitted %d, rejected %d, in flight %d, " +
+                 "want 1, 1, 1.", a.Admitted(), a.Rejected(),
+                 a.InFlight())
+    }
+
+    in := mpserver.GetChan()
+    go mpserver.StringWriter(in)
+    job.SetResult("ok")
+    in <- job
+    close(in)
+    <- firstDone
+    if (first.Code != http.StatusOK) {
+        t.Errorf("Response code is %d, want 200.", first.Code)
+    }
+    if (a.InFlight() != 0) {
+        t.Errorf("%d jobs in flight, want 0.", a.InFlight())
+    }
+}
+
+func TestAdmissionReleasesLostJobs(t *testing.T) {
+    a := mpserver.NewAdmission(mpserver.AdmissionPolicy{
+        MaxInFlight: 1,
+        MaxWait: 100*time.Millisecond,
+    })
+    out := mpserver.GetChan()
+    h := mpserver.Handler(out, mpserver.WithAdmission(a),
+                          mpserver.WithDeadline(10*time.Millisecond))
+    // The pipeline drops every job.
+    go func () {
+        for range out {}
+    }()
+    defer close(out)
+
+    for i := 0; i < 3; i++ {
+        rec, done := serveAsync(h, context.Background())
+        <- done
+        if (rec.Code != http.StatusInternalServerError) {
+            t.Errorf("Response code is %d, want 500.", rec.Code)
+        }
+    }
+    if (a.Admitted() != 3 || a.Rejected() != 0 || a.InFlight() != 0) {
+        t.Errorf("Admitted %d, rejected %d, in flight %d, " +
+                 "want 3, 0, 0.", a.Admitted(), a.Rejected(),
+                 a.InFlight())
+    }
+}
+
+func TestAdmissionReleasesAbandonedJobs(t *testing.T) {
+    a := mpserver.NewAdmission(mpserver.AdmissionPolicy{MaxInFlight: 1})
+    out := mpserver.GetChan()
+    h := mpserver.Handler(out, mpserver.WithAdmission(a))
+
+    ctx, cancel := context.WithCancel(context.Background())
+    _, done := serveAsync(h, ctx)
+    job := <- out
+    cancel()
+    <- done
+    if (a.InFlight() != 0) {
+        t.Errorf("%d jobs in flight, want 0.", a.InFlight())
+    }
+
+    // The late write of the abandoned job is dropped and the slot
+    // is free for the next request.
+    in := mpserver.GetChan()
+    go mpserver.StringWriter(in)
+    defer close(in)
+    in <- job
+    rec, done := serveAsync(h, context.Background())
+    job = <- out
+    job.SetResult("ok")
+    in <- job
+    <- done
+    if (rec.Code != http.StatusOK || rec.Body.String() != "ok") {
+        t.Errorf("Got %d %q, want 200 ok.", rec.Code, rec.Body.String())
+    }
+}
@@ -0,0 +1,49 @@
+package mpserver
+
+// Key is a typed key for the attribute store of jobs. The 
+// attribute store holds side data, such as the authenticated 
+// user, route parameters or timing information, that components
+// attach to a job without overwriting its result field. Keys are
+// compared by identity, so two keys created by separate calls to
+// NewKey never clash, even if they have the same name.
+type Key[T any] struct {
+    name string
+}
+
+// NewKey returns a new key for attributes of type T. The name is
+// only used for debugging.
+func NewKey[T any](name string) *Key[T] {
+    return &Key[T]{name}
+}
+
+// String returns the name of the key.
+func (key *Key[T]) String() string {
+    return key.name
+}
+
+// Set stores the provided value under this key in the attribute
+// store of the job, replacing any previous value.
+func (key *Key[T]) Set(job Job, value T) {
+    job.setAttr(key, value)
+}
+
+// Get returns the value stored under this key in the attribute
+// store of the job and a boolean that indicates whether a value
+// was stored.
+func (key *Key[T]) Get(job Job) (T, bool) {
+    value, ok := job.getAttr(key)
+    if (!ok) {
+        var zero T
+        return zero, false
+    }
+    // A nil stored for an interface type T is not a T, so the
+    // unchecked assertion would panic.
+    v, _ := value.(T)
+    return v, true
+}
+
+// Delete removes the value stored under this key from the 
+// attribute store of the job.
+func (key *Key[T]) Delete(job Job) {
+    job.deleteAttr(key)
+}
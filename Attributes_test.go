@@ -0,0 +1,47 @@
+package mpserver_test
+
+import (
+    "testing"
+    "mpserver"
+    "mpserver/mpservertest"
+)
+
+func TestKeySetGetDelete(t *testing.T) {
+    key := mpserver.NewKey[int]("count")
+    job := mpservertest.NewGetJob()
+    if _, ok := key.Get(job); ok {
+        t.Fatal("Get found a value before Set.")
+    }
+    key.Set(job, 1)
+    key.Set(job, 2)
+    if v, ok := key.Get(job); !ok || v != 2 {
+        t.Errorf("Get returned %d, %v, want 2, true.", v, ok)
+    }
+    key.Delete(job)
+    if _, ok := key.Get(job); ok {
+        t.Error("Get found a value after Delete.")
+    }
+    if (key.String() != "count") {
+        t.Errorf("String is %q, want count.", key.String())
+    }
+}
+
+func TestKeysDontClash(t *testing.T) {
+    a := mpserver.NewKey[string]("name")
+    b := mpserver.NewKey[string]("name")
+    job := mpservertest.NewGetJob()
+    a.Set(job, "a")
+    if _, ok := b.Get(job); ok {
+        t.Error("Keys with the same name share a value.")
+    }
+}
+
+func TestKeyNilInterface(t *testing.T) {
+    key := mpserver.NewKey[error]("err")
+    job := mpservertest.NewGetJob()
+    key.Set(job, nil)
+    v, ok := key.Get(job)
+    if (!ok || v != nil) {
+        t.Errorf("Get returned %v, %v, want nil, true.", v, ok)
+    }
+}
@@ -0,0 +1,14 @@
+package mpserver_test
+
+import (
+    "testing"
+    "mpserver/mpservertest"
+)
+
+// BenchmarkCore runs the benchmarks of the core components and 
+// writers, as mpload -bench does.
+func BenchmarkCore(b *testing.B) {
+    for _, benchmark := range mpservertest.CoreBenchmarks() {
+        b.Run(benchmark.Name, benchmark.F)
+    }
+}
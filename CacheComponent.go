@@ -2,7 +2,7 @@ package mpserver
 
 import (
 	"net/http"
-	"log"
+	"sort"
 	"time"
 	"strings"
 )
@@ -27,13 +27,21 @@ func isCachable(method string) bool {
 	return stringInSlice(method, CachableMethods)
 }
 
-// requestToString converts a request to string.
+// requestToString converts a request to string. The request id 
+// is left out, as it is unique for every request. The headers are
+// sorted, so that equal requests give equal strings.
 func requestToString(r *http.Request) string {
+    keys := make([]string, 0, len(r.Header))
+    for key := range r.Header {
+        if (key != RequestIDHeader) {
+            keys = append(keys, key)
+        }
+    }
+    sort.Strings(keys)
     res := r.Method + r.URL.String() + "HEADERS:"
-    for key, value := range r.Header {
-        res += key + ":" + strings.Join(value, "") + ";"
+    for _, key := range keys {
+        res += key + ":" + strings.Join(r.Header[key], "") + ";"
     }
-    log.Println(res)
     return res
 }
 
@@ -46,6 +54,14 @@ func requestToString(r *http.Request) string {
 // Then the result needs to be computed again.
 func CacheComponent(cache Storage, worker Component, 
 					expiration time.Duration) Component {
+	return CacheComponentWithClock(
+		cache, worker, expiration, SystemClock)
+}
+
+// CacheComponentWithClock returns a CacheComponent that measures
+// the expiration of the cached values by the provided clock.
+func CacheComponentWithClock(cache Storage, worker Component, 
+		expiration time.Duration, clock Clock) Component {
 	return func (in <-chan Job, out chan<- Job) {
 		toWorker := GetChan()
 		fromWorker := GetChan()
@@ -57,18 +73,33 @@ func CacheComponent(cache Storage, worker Component,
 									now time.Time) {
 			toWorker <- job
             res := <- fromWorker
-            // Store the result in the cache.
-            cache.Set(key, StorageValue{
-            	res.GetResult(), now.Add(expiration)})
+            // Store the result in the cache, unless the work was
+            // skipped because the client went away.
+            if (!isCancelled(res)) {
+            	cache.Set(key, StorageValue{
+            		res.GetResult(), now.Add(expiration)})
+            }
             out <- res
 		}
 
 	    for job := range in {
+	    	// Skip the job if its client has gone away.
+	    	if (skipCancelled(job)) {
+	    		out <- job
+	    		continue
+	    	}
+
 	    	// Check if the HTTP method used is cachable.
 	    	if (isCachable(job.GetRequest().Method)) {
 	    		key := requestToString(job.GetRequest())
 		        storageValue, in := cache.Get(key)
-		        now := time.Now()
+		        now := clock.Now()
+		        job.Logger().Debug("cache lookup", 
+		        	"request_id", job.RequestID(),
+		        	"component", "CacheComponent", 
+		        	"method", job.GetRequest().Method,
+		        	"url", job.GetRequest().URL.String(),
+		        	"hit", in && storageValue.Time.After(now))
 
 		        if (in) {
 		        	// The result for this request is in 
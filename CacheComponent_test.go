@@ -0,0 +1,62 @@
+package mpserver_test
+
+import (
+    "testing"
+    "time"
+    "mpserver"
+    "mpserver/mpservertest"
+)
+
+// counter returns a component that sets the result of every job
+// to the number of jobs it has processed so far.
+func counter() mpserver.Component {
+    calls := 0
+    return mpserver.MakeComponent(func (job mpserver.Job) {
+        calls++
+        job.SetResult(calls)
+    })
+}
+
+func TestCacheComponent(t *testing.T) {
+    c := mpserver.CacheComponent(
+        mpserver.NewMemStorage(), counter(), time.Minute)
+    a, _ := mpservertest.NewRequestJob("GET", "/a", nil)
+    b, _ := mpservertest.NewRequestJob("GET", "/a", nil)
+    a.GetRequest().Header.Set(mpserver.RequestIDHeader, "1")
+    b.GetRequest().Header.Set(mpserver.RequestIDHeader, "2")
+    post, _ := mpservertest.NewRequestJob("POST", "/a", nil)
+    run(t, c, a, b, post)
+    if (a.GetResult() != 1 || b.GetResult() != 1) {
+        t.Errorf("Results are %v and %v, want a cache hit.", 
+            a.GetResult(), b.GetResult())
+    }
+    if (post.GetResult() != 2) {
+        t.Errorf("POST result is %v, want 2.", post.GetResult())
+    }
+}
+
+func TestCacheComponentExpiration(t *testing.T) {
+    clock := mpservertest.NewFakeClock(time.Now())
+    c := mpserver.CacheComponentWithClock(
+        mpserver.NewMemStorage(), counter(), time.Minute, clock)
+    in := mpserver.GetChan()
+    out := mpserver.GetChan()
+    go c(in, out)
+    defer close(in)
+    get := func () interface{} {
+        in <- mpservertest.NewGetJob()
+        return (<- out).GetResult()
+    }
+
+    if res := get(); res != 1 {
+        t.Fatalf("Result is %v, want 1.", res)
+    }
+    clock.Advance(time.Minute - time.Second)
+    if res := get(); res != 1 {
+        t.Errorf("Result before expiration is %v, want 1.", res)
+    }
+    clock.Advance(time.Second)
+    if res := get(); res != 2 {
+        t.Errorf("Result after expiration is %v, want 2.", res)
+    }
+}
@@ -0,0 +1,388 @@
+package mpserver
+
+import (
+    "errors"
+    "fmt"
+    "net/http"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+// ViolationKind is a kind of a violation of the Component 
+// contract.
+type ViolationKind string
+
+const (
+    // ExtraOutput means that a component output a job more times
+    // than it received it.
+    ExtraOutput ViolationKind = "extra output"
+    // UnknownOutput means that a component output a job that it
+    // never received.
+    UnknownOutput ViolationKind = "output of a job that was never input"
+    // MissingOutput means that a component terminated without 
+    // outputting a job that it received.
+    MissingOutput ViolationKind = "missing output"
+    // EarlyClose means that a component closed its output 
+    // channel before its input channel was closed.
+    EarlyClose ViolationKind = "output closed before input"
+    // OutputAfterClose means that a component output a job after
+    // it closed its output channel.
+    OutputAfterClose ViolationKind = "output after close"
+    // MissingClose means that a component didn't close its output
+    // channel within the output timeout after its input channel 
+    // was closed.
+    MissingClose ViolationKind = "output not closed after input"
+)
+
+// ContractViolation describes a violation of the Component 
+// contract by a component.
+type ContractViolation struct {
+    Component string
+    Kind ViolationKind
+    RequestID string // Id of the affected request, if known.
+    logger Logger // Logger of the pipeline of the component.
+}
+
+func (v ContractViolation) Error() string {
+    if (v.RequestID == "") {
+        return fmt.Sprintf("Component %s violated its contract: %s.",
+            v.Component, v.Kind)
+    }
+    return fmt.Sprintf(
+        "Component %s violated its contract: %s (request %s).",
+        v.Component, v.Kind, v.RequestID)
+}
+
+// logViolation reports the violation using the logger of the 
+// pipeline that the component is part of, that is the logger of 
+// the affected job or of the last job that the component received.
+func logViolation(v ContractViolation) {
+    v.logger.Error("component contract violated", 
+        "component", v.Component, "violation", string(v.Kind),
+        "request_id", v.RequestID)
+}
+
+// DefaultOutputTimeout is the time a checked component waits for
+// its worker to output a job or to close its output channel, 
+// unless the WithOutputTimeout option is used.
+const DefaultOutputTimeout = time.Minute
+
+// checkConfig holds the settings of a checked component.
+type checkConfig struct {
+    timeout time.Duration
+    clock Clock
+}
+
+// CheckOption configures a component created by CheckedComponent
+// or CheckedComponentReport.
+type CheckOption func (config *checkConfig)
+
+// WithOutputTimeout returns a CheckOption that sets the time the 
+// worker may hold a job, and the time it may take to close its 
+// output channel after its input channel was closed. Zero 
+// disables both checks, so that a faulty worker is only detected
+// once it closes its output channel.
+func WithOutputTimeout(timeout time.Duration) CheckOption {
+    return func (config *checkConfig) {
+        config.timeout = timeout
+    }
+}
+
+// WithCheckClock returns a CheckOption that sets the clock used 
+// for the output timeout.
+func WithCheckClock(clock Clock) CheckOption {
+    return func (config *checkConfig) {
+        config.clock = clock
+    }
+}
+
+// CheckedComponent returns a component that runs the worker and
+// checks at runtime that it satisfies the Component contract. 
+// Violations are logged with the provided name of the worker. 
+// See CheckedComponentReport.
+func CheckedComponent(name string, worker Component, 
+                      opts ...CheckOption) Component {
+    return CheckedComponentReport(name, worker, logViolation, opts...)
+}
+
+// CheckedComponentReport returns a component that runs the worker 
+// and checks that it outputs exactly one job for every job that 
+// it receives, doesn't output jobs that it never received, and 
+// closes its output channel only after its input channel was 
+// closed. Every violation is passed to the report function, 
+// which may be called concurrently.
+//
+// The returned component keeps the contract itself as far as it
+// can, so that a faulty worker doesn't deadlock or crash the 
+// pipeline. Extra and unknown outputs are dropped. Jobs that the 
+// worker didn't output when it closed its output channel are 
+// output with an error in the result field and reported as 
+// missing outputs. So are jobs that arrive after the worker 
+// closed its output channel, but those aren't reported. 
+//
+// Jobs that the worker holds for longer than the output timeout
+// are reported as missing outputs too, between one and one and a
+// half times the timeout after they were received. As the worker
+// might still be using such a job, it isn't output. Instead its
+// client gets a response with the 500 response code right away,
+// and the output of the job by the worker is dropped and 
+// reported as extra. If the worker doesn't close its output 
+// channel within the timeout after the input channel was closed,
+// the clients of the jobs that it holds get the same response, 
+// the returned component closes its output channel and drops 
+// anything that the worker outputs later.
+//
+// The checks have limits. A worker that sends on its closed 
+// output channel is only caught if it does so from the goroutine
+// that runs the worker, as a panic in any other goroutine can't 
+// be recovered and crashes the program. A worker that never 
+// closes its output channel leaks its goroutine. A job that the
+// worker legitimately holds for longer than the timeout is 
+// reported too, so the timeout has to be set above the longest 
+// expected processing time.
+func CheckedComponentReport(name string, worker Component, 
+        report func (ContractViolation), opts ...CheckOption) Component {
+    config := checkConfig{timeout: DefaultOutputTimeout, clock: SystemClock}
+    for _, opt := range opts {
+        opt(&config)
+    }
+    failure := errors.New("Component " + name + 
+        " violated its contract.")
+    return func (in <-chan Job, out chan<- Job) {
+        toWorker := GetChan()
+        fromWorker := GetChan()
+        inputKey := NewKey[bool]("checked:" + name)
+
+        var lock sync.Mutex
+        // Arrival times of the jobs that the worker holds, oldest
+        // first.
+        pending := make(map[Job][]time.Time)
+        inClosed := false
+        gaveUp := false // The worker didn't close its output.
+        workerClosed := make(chan bool)
+
+        // The logger of the last received job, for violations 
+        // that don't concern a particular job.
+        var lastLogger Logger = DefaultLogger()
+        violation := func (kind ViolationKind, job Job) {
+            v := ContractViolation{Component: name, Kind: kind}
+            lock.Lock()
+            v.logger = lastLogger
+            lock.Unlock()
+            if (job != nil) {
+                v.RequestID = job.RequestID()
+                v.logger = job.Logger()
+            }
+            report(v)
+        }
+
+        // Jobs are output from several goroutines, so the sends 
+        // are serialised by the outLock, which also guards the 
+        // closing of the output channel.
+        var outLock sync.Mutex
+        outClosed := false
+        send := func (job Job) {
+            outLock.Lock()
+            defer outLock.Unlock()
+            if (!outClosed) {
+                out <- job
+            }
+        }
+        // fail outputs a job that the worker doesn't hold with an
+        // error in the result field.
+        fail := func (job Job) {
+            job.SetResponseCode(http.StatusInternalServerError)
+            job.SetResult(failure)
+            send(job)
+        }
+        // take removes the oldest arrival of the job from pending
+        // and returns false if the worker doesn't hold the job. It
+        // must be called with the lock held.
+        take := func (job Job) bool {
+            arrivals := pending[job]
+            if (len(arrivals) == 0) {
+                return false
+            }
+            if (len(arrivals) == 1) {
+                delete(pending, job)
+            } else {
+                pending[job] = arrivals[1:]
+            }
+            return true
+        }
+
+        // Run the worker and catch outputs after close.
+        workerDone := make(chan bool)
+        go func () {
+            defer close(workerDone)
+            defer func () {
+                if r := recover(); r != nil {
+                    err, ok := r.(error)
+                    if (!ok || err.Error() != "send on closed channel") {
+                        panic(r)
+                    }
+                    violation(OutputAfterClose, nil)
+                }
+            }()
+            worker(toWorker, fromWorker)
+        }()
+
+        // Check the outputs of the worker.
+        collected := make(chan bool)
+        go func () {
+            defer close(collected)
+            for job := range fromWorker {
+                // The outLock is held from taking the job until it
+                // is sent, so that the output channel isn't closed
+                // in between.
+                outLock.Lock()
+                lock.Lock()
+                held := take(job)
+                late := gaveUp
+                lock.Unlock()
+                if (held) {
+                    out <- job
+                }
+                outLock.Unlock()
+
+                if (!held && !late) {
+                    if _, seen := inputKey.Get(job); seen {
+                        violation(ExtraOutput, job)
+                    } else {
+                        violation(UnknownOutput, job)
+                    }
+                }
+            }
+            lock.Lock()
+            early := !inClosed
+            lock.Unlock()
+            if (early) {
+                violation(EarlyClose, nil)
+            }
+            close(workerClosed)
+        }()
+
+        // Answer the clients of the jobs that the worker holds for
+        // too long.
+        stopExpiry := make(chan bool)
+        expiryDone := make(chan bool)
+        go func () {
+            defer close(expiryDone)
+            if (config.timeout <= 0) {
+                return
+            }
+            for {
+                timer := config.clock.NewTimer(config.timeout / 2)
+                select {
+                    case <- stopExpiry: {
+                        timer.Stop()
+                        return
+                    }
+                    case <- timer.C(): {}
+                }
+                deadline := config.clock.Now().Add(-config.timeout)
+                var expired []Job
+                lock.Lock()
+                for job, arrivals := range pending {
+                    for len(arrivals) > 0 && 
+                            !arrivals[0].After(deadline) {
+                        arrivals = arrivals[1:]
+                        expired = append(expired, job)
+                    }
+                    if (len(arrivals) == 0) {
+                        delete(pending, job)
+                    } else {
+                        pending[job] = arrivals
+                    }
+                }
+                lock.Unlock()
+                for _, job := range expired {
+                    violation(MissingOutput, job)
+                    job.abort(failure)
+                }
+            }
+        }()
+
+        for job := range in {
+            inputKey.Set(job, true)
+            lock.Lock()
+            lastLogger = job.Logger()
+            pending[job] = append(pending[job], config.clock.Now())
+            lock.Unlock()
+            select {
+                case toWorker <- job: {}
+                case <- workerClosed: {
+                    lock.Lock()
+                    arrivals := pending[job]
+                    if (len(arrivals) <= 1) {
+                        delete(pending, job)
+                    } else {
+                        pending[job] = arrivals[:len(arrivals) - 1]
+                    }
+                    lock.Unlock()
+                    fail(job)
+                }
+            }
+        }
+        lock.Lock()
+        inClosed = true
+        lock.Unlock()
+        close(toWorker)
+
+        // Wait for the worker to close its output channel and to
+        // return, but not for longer than the timeout.
+        var timeout <-chan time.Time
+        if (config.timeout > 0) {
+            timer := config.clock.NewTimer(config.timeout)
+            defer timer.Stop()
+            timeout = timer.C()
+        }
+        stuck := false // The worker might still hold jobs.
+        select {
+            case <- collected: {
+                select {
+                    case <- workerDone: {}
+                    case <- timeout: stuck = true
+                }
+            }
+            case <- timeout: {
+                violation(MissingClose, nil)
+                stuck = true
+            }
+        }
+        close(stopExpiry)
+        <- expiryDone
+
+        // Output the jobs that the worker didn't output.
+        lock.Lock()
+        gaveUp = true
+        missing := pending
+        pending = make(map[Job][]time.Time)
+        lock.Unlock()
+        for job, arrivals := range missing {
+            for _ = range arrivals {
+                violation(MissingOutput, job)
+                if (stuck) {
+                    job.abort(failure)
+                } else {
+                    fail(job)
+                }
+            }
+        }
+        outLock.Lock()
+        outClosed = true
+        close(out)
+        outLock.Unlock()
+    }
+}
+
+var debugComponents atomic.Bool
+
+// SetDebug enables or disables the debug mode. In debug mode 
+// LinkComponents wraps every linked component in a 
+// CheckedComponent, named after the function of the component 
+// and its position in the chain.
+func SetDebug(enabled bool) {
+    debugComponents.Store(enabled)
+}
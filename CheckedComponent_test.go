@@ -0,0 +1,246 @@
+package mpserver_test
+
+import (
+    "net/http"
+    "sync"
+    "sync/atomic"
+    "testing"
+    "time"
+    "mpserver"
+    "mpserver/mpservertest"
+)
+
+// violations records the violations reported by checked 
+// components.
+type violations struct {
+    lock sync.Mutex
+    kinds []mpserver.ViolationKind
+}
+
+func (v *violations) report(violation mpserver.ContractViolation) {
+    v.lock.Lock()
+    defer v.lock.Unlock()
+    v.kinds = append(v.kinds, violation.Kind)
+}
+
+// expect fails the test unless exactly the provided kinds were 
+// reported, in any order.
+func (v *violations) expect(t *testing.T, kinds ...mpserver.ViolationKind) {
+    t.Helper()
+    v.lock.Lock()
+    defer v.lock.Unlock()
+    count := make(map[mpserver.ViolationKind]int)
+    for _, kind := range v.kinds {
+        count[kind]++
+    }
+    for _, kind := range kinds {
+        count[kind]--
+    }
+    for kind, n := range count {
+        if (n != 0) {
+            t.Errorf("Reported %v, want %v (%q differs).", 
+                v.kinds, kinds, kind)
+            return
+        }
+    }
+}
+
+func TestCheckedComponentValid(t *testing.T) {
+    var v violations
+    c := mpserver.CheckedComponentReport("const", 
+        mpserver.ConstantComponent(1), v.report)
+    run(t, c, newJobs(5)...)
+    v.expect(t)
+}
+
+func TestCheckedComponentOutputs(t *testing.T) {
+    // Drops every second job.
+    dropper := func (in <-chan mpserver.Job, out chan<- mpserver.Job) {
+        i := 0
+        for job := range in {
+            if (i % 2 == 0) {
+                out <- job
+            }
+            i++
+        }
+        close(out)
+    }
+    var v violations
+    res := run(t, mpserver.CheckedComponentReport(
+        "dropper", dropper, v.report), newJobs(4)...)
+    v.expect(t, mpserver.MissingOutput, mpserver.MissingOutput)
+    failed := 0
+    for _, job := range res {
+        if (isError(job)) {
+            failed++
+        }
+    }
+    if (failed != 2) {
+        t.Errorf("%d jobs failed, want 2.", failed)
+    }
+
+    // Outputs every job twice and a job it never received.
+    duplicator := func (in <-chan mpserver.Job, out chan<- mpserver.Job) {
+        for job := range in {
+            out <- job
+            out <- job
+        }
+        out <- mpservertest.NewGetJob()
+        close(out)
+    }
+    v = violations{}
+    run(t, mpserver.CheckedComponentReport(
+        "duplicator", duplicator, v.report), newJobs(2)...)
+    v.expect(t, mpserver.ExtraOutput, mpserver.ExtraOutput, 
+        mpserver.UnknownOutput)
+}
+
+func TestCheckedComponentEarlyClose(t *testing.T) {
+    early := func (in <-chan mpserver.Job, out chan<- mpserver.Job) {
+        job := <- in
+        out <- job
+        close(out)
+        out <- job
+    }
+    var v violations
+    run(t, mpserver.CheckedComponentReport("early", early, v.report),
+        newJobs(3)...)
+    v.expect(t, mpserver.EarlyClose, mpserver.OutputAfterClose)
+}
+
+func TestCheckedComponentMissingOutputAtRuntime(t *testing.T) {
+    clock := mpservertest.NewFakeClock(time.Now())
+    received := make(chan bool)
+    // Holds the first job forever.
+    holder := func (in <-chan mpserver.Job, out chan<- mpserver.Job) {
+        <- in
+        received <- true
+        for job := range in {
+            out <- job
+        }
+        close(out)
+    }
+    var v violations
+    c := mpserver.CheckedComponentReport("holder", holder, v.report,
+        mpserver.WithOutputTimeout(time.Minute), 
+        mpserver.WithCheckClock(clock))
+    in := mpserver.GetChan()
+    out := mpserver.GetChan()
+    go c(in, out)
+
+    job, rec := mpservertest.NewRequestJob("GET", "/", nil)
+    in <- job
+    <- received
+    if (!clock.BlockUntil(1, time.Second)) {
+        t.Fatal("Component isn't waiting for the clock.")
+    }
+    clock.Advance(time.Minute)
+    // The client is answered while the input is still open, but 
+    // the job isn't output, as the worker still holds it.
+    if err := rec.Wait(time.Second); err != nil {
+        t.Fatal(err)
+    }
+    if (rec.Code != http.StatusInternalServerError) {
+        t.Errorf("Response code is %d, want 500.", rec.Code)
+    }
+    close(in)
+    for _ = range out {
+        t.Error("Held job was output.")
+    }
+    v.expect(t, mpserver.MissingOutput)
+}
+
+func TestCheckedComponentMissingClose(t *testing.T) {
+    clock := mpservertest.NewFakeClock(time.Now())
+    // Never closes its output channel.
+    stuck := func (in <-chan mpserver.Job, out chan<- mpserver.Job) {
+        for _ = range in {}
+    }
+    var v violations
+    c := mpserver.CheckedComponentReport("stuck", stuck, v.report,
+        mpserver.WithOutputTimeout(time.Minute), 
+        mpserver.WithCheckClock(clock))
+    in := mpserver.GetChan()
+    out := mpserver.GetChan()
+    go c(in, out)
+    job, rec := mpservertest.NewRequestJob("GET", "/", nil)
+    in <- job
+    close(in)
+
+    // The expiry loop and the wait for the close.
+    if (!clock.BlockUntil(2, time.Second)) {
+        t.Fatal("Component isn't waiting for the clock.")
+    }
+    // Fires the wait for the close and the expiry of the held 
+    // job, which is failed only once either way.
+    clock.Advance(time.Minute)
+    for _ = range out {
+        t.Error("Job held by the stuck worker was output.")
+    }
+    if (!rec.Completed() || rec.Code != http.StatusInternalServerError) {
+        t.Errorf("Client got %d, want 500.", rec.Code)
+    }
+    v.expect(t, mpserver.MissingClose, mpserver.MissingOutput)
+}
+
+func TestCheckedComponentSlowWorker(t *testing.T) {
+    clock := mpservertest.NewFakeClock(time.Now())
+    received := make(chan bool)
+    var stop atomic.Bool
+    // Keeps working on the job until it is stopped and then 
+    // outputs it correctly.
+    slow := func (in <-chan mpserver.Job, out chan<- mpserver.Job) {
+        for job := range in {
+            received <- true
+            for i := 0; !stop.Load(); i++ {
+                job.SetResult(i)
+            }
+            out <- job
+        }
+        close(out)
+    }
+    var v violations
+    c := mpserver.CheckedComponentReport("slow", slow, v.report,
+        mpserver.WithOutputTimeout(time.Minute), 
+        mpserver.WithCheckClock(clock))
+    in := mpserver.GetChan()
+    out := mpserver.GetChan()
+    go c(in, out)
+
+    job, rec := mpservertest.NewRequestJob("GET", "/", nil)
+    in <- job
+    <- received
+    if (!clock.BlockUntil(1, time.Second)) {
+        t.Fatal("Component isn't waiting for the clock.")
+    }
+    // The job expires while the worker writes its result, which 
+    // the race detector reports if the component touches the job.
+    clock.Advance(time.Minute)
+    if err := rec.Wait(time.Second); err != nil {
+        t.Fatal(err)
+    }
+    stop.Store(true)
+    close(in)
+    for _ = range out {
+        t.Error("Late output of the worker was passed on.")
+    }
+    if (rec.Code != http.StatusInternalServerError) {
+        t.Errorf("Response code is %d, want 500.", rec.Code)
+    }
+    v.expect(t, mpserver.MissingOutput, mpserver.ExtraOutput)
+}
+
+func TestDebugLinkComponents(t *testing.T) {
+    mpserver.SetDebug(true)
+    defer mpserver.SetDebug(false)
+    dropper := func (in <-chan mpserver.Job, out chan<- mpserver.Job) {
+        for _ = range in {}
+        close(out)
+    }
+    for _, job := range run(t, mpserver.LinkComponents(
+            mpserver.ConstantComponent(1), dropper), newJobs(2)...) {
+        if (!isError(job)) {
+            t.Errorf("Result is %v, want an error.", job.GetResult())
+        }
+    }
+}
@@ -0,0 +1,73 @@
+package mpserver
+
+import (
+    "time"
+)
+
+// Clock is the source of time used by components that depend on
+// it, such as the DynamicLoadBalancer, CacheComponent, 
+// SessionManager and StorageCleaner. It allows replacing the 
+// system time in tests, see the mpservertest package.
+type Clock interface {
+    // Now returns the current time.
+    Now() time.Time
+
+    // After returns a channel that receives the current time 
+    // after the duration d elapses.
+    After(d time.Duration) <-chan time.Time
+
+    // Sleep pauses the calling goroutine for the duration d.
+    Sleep(d time.Duration)
+
+    // NewTimer returns a timer that sends the current time on its
+    // channel after the duration d elapses, unless it is stopped.
+    // Components use it instead of After when they may stop 
+    // waiting early, so that the clock doesn't keep the wait.
+    NewTimer(d time.Duration) Timer
+}
+
+// Timer is a single event created by a Clock.
+type Timer interface {
+    // C returns the channel on which the time is sent.
+    C() <-chan time.Time
+
+    // Stop prevents the timer from firing. It returns false if 
+    // the timer already fired or was stopped.
+    Stop() bool
+}
+
+// systemClock is the Clock that uses the time package.
+type systemClock struct {}
+
+func (systemClock) Now() time.Time {
+    return time.Now()
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+    return time.After(d)
+}
+
+func (systemClock) Sleep(d time.Duration) {
+    time.Sleep(d)
+}
+
+func (systemClock) NewTimer(d time.Duration) Timer {
+    return systemTimer{time.NewTimer(d)}
+}
+
+// systemTimer is the Timer of the systemClock.
+type systemTimer struct {
+    timer *time.Timer
+}
+
+func (t systemTimer) C() <-chan time.Time {
+    return t.timer.C
+}
+
+func (t systemTimer) Stop() bool {
+    return t.timer.Stop()
+}
+
+// SystemClock is the Clock that reports the system time. It is 
+// used by the components that don't take a Clock argument.
+var SystemClock Clock = systemClock{}
@@ -5,6 +5,7 @@ import (
     "os"
     "strings"
     "errors"
+    "fmt"
 )
 
 // Component is a generic part of the pipeline with an input and 
@@ -34,8 +35,17 @@ func MakeComponent(f ComponentFunc) Component {
 // LinkComponents takes any number of components and returns a 
 // component that behaves as their linear combination. That is as 
 // a pipeline constructed from these components in the order in 
-// which they are provided.
+// which they are provided. In debug mode the components are 
+// checked for violations of the Component contract, see SetDebug.
 func LinkComponents(components ...Component) Component {
+    if (debugComponents.Load()) {
+        checked := make([]Component, len(components))
+        for i, c := range components {
+            checked[i] = CheckedComponent(
+                fmt.Sprintf("%s[%d]", funcName(c), i), c)
+        }
+        components = checked
+    }
     return func (in <-chan Job, out chan<- Job) {
         iters := len(components) - 1
         if (iters == 0) {
@@ -89,6 +99,12 @@ func FileComponent (in <-chan Job, out chan<- Job) {
     }
 
     for job := range in {
+        // Skip the job if its client has gone away.
+        if (skipCancelled(job)) {
+            out <- job
+            continue
+        }
+
         // Check if a path is provided
         path, ok := job.GetResult().(string)
         if (!ok) {
@@ -0,0 +1,198 @@
+package mpserver_test
+
+import (
+    "errors"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+    "mpserver"
+    "mpserver/mpservertest"
+)
+
+// newJobs returns n jobs for GET requests of the root path.
+func newJobs(n int) []mpserver.Job {
+    jobs := make([]mpserver.Job, n)
+    for i := range jobs {
+        jobs[i] = mpservertest.NewGetJob()
+    }
+    return jobs
+}
+
+// run runs the component with the jobs and fails the test if it 
+// doesn't output all of them.
+func run(t *testing.T, c mpserver.Component, 
+         jobs ...mpserver.Job) []mpserver.Job {
+    t.Helper()
+    res, err := mpservertest.RunComponent(c, jobs...)
+    if (err != nil) {
+        t.Fatal(err)
+    }
+    if (len(res) != len(jobs)) {
+        t.Fatalf("Got %d of %d jobs.", len(res), len(jobs))
+    }
+    return res
+}
+
+// eventually fails the test if the condition doesn't hold within
+// a second. It is used to wait for goroutines to react to a fake
+// clock.
+func eventually(t *testing.T, what string, cond func () bool) {
+    t.Helper()
+    deadline := time.Now().Add(time.Second)
+    for !cond() {
+        if (time.Now().After(deadline)) {
+            t.Fatal(what)
+        }
+        time.Sleep(time.Millisecond)
+    }
+}
+
+func TestMakeComponent(t *testing.T) {
+    c := mpserver.MakeComponent(func (job mpserver.Job) {
+        job.SetResult(job.GetRequest().URL.Path)
+    })
+    job, _ := mpservertest.NewRequestJob("GET", "/path", nil)
+    res := run(t, c, job)
+    if (res[0].GetResult() != "/path") {
+        t.Errorf("Result is %v, want /path.", res[0].GetResult())
+    }
+}
+
+func TestLinkComponents(t *testing.T) {
+    var order []int
+    step := func (i int) mpserver.Component {
+        return mpserver.MakeComponent(func (job mpserver.Job) {
+            order = append(order, i)
+        })
+    }
+    run(t, mpserver.LinkComponents(step(1), step(2), step(3)), 
+        mpservertest.NewGetJob())
+    if (len(order) != 3 || order[0] != 1 || order[2] != 3) {
+        t.Errorf("Components ran in order %v.", order)
+    }
+}
+
+func TestConstantComponent(t *testing.T) {
+    for _, job := range run(t, mpserver.ConstantComponent(42), 
+                            newJobs(3)...) {
+        if (job.GetResult() != 42) {
+            t.Errorf("Result is %v, want 42.", job.GetResult())
+        }
+    }
+}
+
+func TestPathMakerAndFileComponent(t *testing.T) {
+    dir := t.TempDir()
+    if err := os.WriteFile(filepath.Join(dir, "a.txt"), 
+                           []byte("a"), 0644); err != nil {
+        t.Fatal(err)
+    }
+    c := mpserver.LinkComponents(
+        mpserver.PathMaker(dir, "/files"), mpserver.FileComponent)
+    found, _ := mpservertest.NewRequestJob("GET", "/files/a.txt", nil)
+    missing, _ := mpservertest.NewRequestJob("GET", "/files/b.txt", nil)
+    run(t, c, found, missing)
+
+    file, ok := found.GetResult().(*os.File)
+    if (!ok) {
+        t.Fatalf("Result is %v, want a file.", found.GetResult())
+    }
+    file.Close()
+    if _, ok := missing.GetResult().(error); !ok {
+        t.Errorf("Result is %v, want an error.", missing.GetResult())
+    }
+}
+
+func TestErrorPasser(t *testing.T) {
+    failed := mpservertest.NewGetJob()
+    err := errors.New("Failed.")
+    failed.SetResult(err)
+    ok := mpservertest.NewGetJob()
+    run(t, mpserver.ErrorPasser(mpserver.ConstantComponent("x")), 
+        failed, ok)
+    if (failed.GetResult() != err) {
+        t.Errorf("Error result was replaced by %v.", failed.GetResult())
+    }
+    if (ok.GetResult() != "x") {
+        t.Errorf("Result is %v, want x.", ok.GetResult())
+    }
+}
+
+func TestPannicHandler(t *testing.T) {
+    c := mpserver.PannicHandler(mpserver.MakeComponent(
+        func (job mpserver.Job) {
+            if (job.GetRequest().URL.Path == "/panic") {
+                panic("Panic.")
+            }
+            job.SetResult("ok")
+        }))
+    var jobs []mpserver.Job
+    for i := 0; i < 6; i++ {
+        path := "/"
+        if (i % 2 == 1) {
+            path = "/panic"
+        }
+        job, _ := mpservertest.NewRequestJob("GET", path, nil)
+        jobs = append(jobs, job)
+    }
+    run(t, c, jobs...)
+    for i, job := range jobs {
+        _, isErr := job.GetResult().(error)
+        if (isErr != (i % 2 == 1)) {
+            t.Errorf("Job %d has result %v.", i, job.GetResult())
+        }
+    }
+}
+
+func TestLoadBalancers(t *testing.T) {
+    balancers := map[string]mpserver.Component{
+        "static": mpserver.StaticLoadBalancer(
+            mpserver.ConstantComponent("x"), 4),
+        "dynamic": mpserver.DynamicLoadBalancer(
+            mpserver.ConstantComponent("x"), 4, 
+            time.Millisecond, time.Millisecond),
+    }
+    for name, c := range balancers {
+        for _, job := range run(t, c, newJobs(20)...) {
+            if (job.GetResult() != "x") {
+                t.Errorf("%s: result is %v, want x.", 
+                    name, job.GetResult())
+            }
+        }
+    }
+}
+
+func TestRouterAndCollector(t *testing.T) {
+    in := mpserver.GetChan()
+    def := mpserver.GetChan()
+    errs := mpserver.GetChan()
+    out := mpserver.GetChan()
+    isErr := func (job mpserver.Job) bool {
+        _, ok := job.GetResult().(error)
+        return ok
+    }
+    go mpserver.Router(in, def, 
+        mpserver.ToOutChans([]chan mpserver.Job{errs}), 
+        []mpserver.Condition{isErr})
+    go mpserver.Collector(
+        mpserver.ToInChans([]chan mpserver.Job{def, errs}), out)
+
+    jobs := newJobs(4)
+    jobs[1].SetResult(errors.New("Failed."))
+    go func () {
+        for _, job := range jobs {
+            in <- job
+        }
+        close(in)
+    }()
+    n := 0
+    for _ = range out {
+        n++
+    }
+    // Collector closes out only after the Router closed both 
+    // of its outputs.
+    if (n != len(jobs)) {
+        t.Errorf("Collected %d of %d jobs.", n, len(jobs))
+    }
+}
@@ -0,0 +1,201 @@
+package mpserver
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "os"
+    "strings"
+    "time"
+)
+
+// Duration is a time.Duration that is written as a string, such
+// as "1m30s", in configuration files.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+    var s string
+    if err := json.Unmarshal(data, &s); err != nil {
+        return err
+    }
+    parsed, err := time.ParseDuration(s)
+    *d = Duration(parsed)
+    return err
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+    return json.Marshal(time.Duration(d).String())
+}
+
+// StorageSpec describes a storage shared by the stages of the 
+// pipelines. Expired values are removed from the storage in the
+// cleanup interval, if it is set.
+type StorageSpec struct {
+    CleanupInterval Duration `json:"cleanupInterval,omitempty"`
+}
+
+// ServerSpec describes the timeouts and limits of the server. 
+// See ServerConfig.
+type ServerSpec struct {
+    ReadTimeout Duration `json:"readTimeout,omitempty"`
+    ReadHeaderTimeout Duration `json:"readHeaderTimeout,omitempty"`
+    WriteTimeout Duration `json:"writeTimeout,omitempty"`
+    IdleTimeout Duration `json:"idleTimeout,omitempty"`
+    MaxHeaderBytes int `json:"maxHeaderBytes,omitempty"`
+    MaxBodyBytes int64 `json:"maxBodyBytes,omitempty"`
+}
+
+// serverConfig converts the spec to a ServerConfig.
+func (spec ServerSpec) serverConfig() ServerConfig {
+    return ServerConfig{
+        ReadTimeout: time.Duration(spec.ReadTimeout),
+        ReadHeaderTimeout: time.Duration(spec.ReadHeaderTimeout),
+        WriteTimeout: time.Duration(spec.WriteTimeout),
+        IdleTimeout: time.Duration(spec.IdleTimeout),
+        MaxHeaderBytes: spec.MaxHeaderBytes,
+        MaxBodyBytes: spec.MaxBodyBytes,
+    }
+}
+
+// RouteSpec describes a pipeline that serves the requests for a
+// path. The pipeline consists of the linked stages, each of 
+// which is wrapped in an ErrorPasser, followed by an ErrorRouter
+// that sends jobs with errors to the error writer and all other 
+// jobs to the writer. The ErrorWriter is used if no error writer
+// is specified.
+type RouteSpec struct {
+    Path string `json:"path"`
+    Stages []Spec `json:"stages,omitempty"`
+    Writer Spec `json:"writer"`
+    ErrorWriter *Spec `json:"errorWriter,omitempty"`
+    MaxBodyBytes int64 `json:"maxBodyBytes,omitempty"`
+    Deadline Duration `json:"deadline,omitempty"`
+}
+
+// Config describes a server and its pipelines, so that they can
+// be changed without recompiling.
+type Config struct {
+    Addr string `json:"addr"`
+    Server ServerSpec `json:"server"`
+    Storages map[string]StorageSpec `json:"storages,omitempty"`
+    Routes []RouteSpec `json:"routes"`
+}
+
+// ParseConfig parses a configuration in the JSON format. Unknown
+// fields are reported as errors.
+func ParseConfig(data []byte) (*Config, error) {
+    decoder := json.NewDecoder(bytes.NewReader(data))
+    decoder.DisallowUnknownFields()
+    config := &Config{}
+    if err := decoder.Decode(config); err != nil {
+        return nil, err
+    }
+    return config, nil
+}
+
+// LoadConfig reads and parses the configuration file with the 
+// provided path.
+func LoadConfig(path string) (*Config, error) {
+    data, err := os.ReadFile(path)
+    if (err != nil) {
+        return nil, err
+    }
+    config, err := ParseConfig(data)
+    if (err != nil) {
+        return nil, fmt.Errorf("%s: %v", path, err)
+    }
+    return config, nil
+}
+
+// routeWriter builds the Writer for the pipeline of the route.
+func (l *Loader) routeWriter(route RouteSpec) (Writer, error) {
+    var errs []error
+    var stages []Component
+    for _, spec := range route.Stages {
+        c, err := l.Component(spec)
+        errs = append(errs, err)
+        stages = append(stages, ErrorPasser(c))
+    }
+    writer, err := l.Writer(route.Writer)
+    errs = append(errs, err)
+    errSpec := Spec{Type: "ErrorWriter"}
+    if (route.ErrorWriter != nil) {
+        errSpec = *route.ErrorWriter
+    }
+    errWriter, err := l.Writer(errSpec)
+    errs = append(errs, err)
+    if err := errors.Join(errs...); err != nil {
+        return nil, fmt.Errorf("route %q: %v", route.Path, err)
+    }
+
+    return SplitErrors(stages, writer, errWriter), nil
+}
+
+// NewServer builds a Server with all pipelines described by the
+// config using the components and writers of the registry. All
+// routes are built before any of them is registered, so nothing
+// is started if the config contains an error. Paths must start 
+// with "/" and be unique.
+func (r *Registry) NewServer(config *Config) (*Server, error) {
+    storages := make(map[string]Storage)
+    for name := range config.Storages {
+        storages[name] = NewMemStorage()
+    }
+    l := NewLoader(r, storages)
+
+    var errs []error
+    writers := make([]Writer, len(config.Routes))
+    paths := make(map[string]bool)
+    for i, route := range config.Routes {
+        if (route.Path == "") {
+            errs = append(errs, fmt.Errorf("route %d: no path.", i))
+        } else if (!strings.HasPrefix(route.Path, "/")) {
+            errs = append(errs, fmt.Errorf(
+                "route %q: path must start with \"/\".", route.Path))
+        } else if (paths[route.Path]) {
+            errs = append(errs, fmt.Errorf(
+                "route %q: duplicate path.", route.Path))
+        }
+        paths[route.Path] = true
+        writer, err := l.routeWriter(route)
+        errs = append(errs, err)
+        writers[i] = writer
+    }
+    if err := errors.Join(errs...); err != nil {
+        return nil, err
+    }
+
+    s := NewServerConfig(config.Addr, config.Server.serverConfig())
+    for i, route := range config.Routes {
+        var opts []HandlerOption
+        if (route.MaxBodyBytes > 0) {
+            opts = append(opts, WithMaxBodyBytes(route.MaxBodyBytes))
+        }
+        if (route.Deadline > 0) {
+            opts = append(opts, 
+                WithDeadline(time.Duration(route.Deadline)))
+        }
+        s.Route(route.Path, writers[i], opts...)
+    }
+
+    for name, spec := range config.Storages {
+        if (spec.CleanupInterval > 0) {
+            shutDown := make(chan bool)
+            go StorageCleaner(storages[name], shutDown, 
+                time.Duration(spec.CleanupInterval))
+            s.onShutdown(func () { close(shutDown) })
+        }
+    }
+    return s, nil
+}
+
+// LoadServer loads the configuration file with the provided path
+// and builds a Server from it using the DefaultRegistry.
+func LoadServer(path string) (*Server, error) {
+    config, err := LoadConfig(path)
+    if (err != nil) {
+        return nil, err
+    }
+    return DefaultRegistry.NewServer(config)
+}
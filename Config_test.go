@@ -0,0 +1,131 @@
+package mpserver_test
+
+import (
+    "context"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+    "mpserver"
+)
+
+func TestConfigServer(t *testing.T) {
+    dir := t.TempDir()
+    if err := os.WriteFile(filepath.Join(dir, "a.txt"), 
+                           []byte("hello"), 0644); err != nil {
+        t.Fatal(err)
+    }
+    cfg, err := mpserver.ParseConfig([]byte(`{
+        "server": {"readTimeout": "5s"},
+        "storages": {"cache": {"cleanupInterval": "1m"}},
+        "routes": [
+            {"path": "/files/", 
+             "stages": [
+                {"type": "PathMaker", 
+                 "args": {"dir": "` + dir + `", "prefix": "/files"}}, 
+                {"type": "FileComponent"}],
+             "writer": {"type": "GenericWriter"}},
+            {"path": "/const", 
+             "stages": [
+                {"type": "CacheComponent", 
+                 "args": {"storage": "cache", "expiration": "1m"}, 
+                 "stages": [{"type": "ConstantComponent", 
+                             "args": {"value": "c"}}]}],
+             "writer": {"type": "StringWriter"}}
+        ]}`))
+    if (err != nil) {
+        t.Fatal(err)
+    }
+    s, err := mpserver.DefaultRegistry.NewServer(cfg)
+    if (err != nil) {
+        t.Fatal(err)
+    }
+    for path, body := range map[string]string{
+            "/files/a.txt": "hello", "/const": "c"} {
+        rec := httptest.NewRecorder()
+        s.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+        if (rec.Code != http.StatusOK || rec.Body.String() != body) {
+            t.Errorf("%s: response is %d %q.", 
+                path, rec.Code, rec.Body.String())
+        }
+    }
+    if err := s.Shutdown(context.Background()); err != nil {
+        t.Fatal(err)
+    }
+}
+
+func TestConfigErrors(t *testing.T) {
+    if _, err := mpserver.ParseConfig([]byte(`{"rutes": []}`)); err == nil {
+        t.Error("Unknown field was accepted.")
+    }
+
+    cfg, err := mpserver.ParseConfig([]byte(`{"routes": [
+        {"path": "/", 
+         "stages": [{"type": "Nope"}, {"type": "CacheComponent"}], 
+         "writer": {"type": "X"}}]}`))
+    if (err != nil) {
+        t.Fatal(err)
+    }
+    _, err = mpserver.DefaultRegistry.NewServer(cfg)
+    if (err == nil) {
+        t.Fatal("Invalid config was loaded.")
+    }
+    // All problems are reported at once.
+    for _, problem := range []string{"Nope", `Unknown writer "X"`, 
+                                     "storage"} {
+        if (!strings.Contains(err.Error(), problem)) {
+            t.Errorf("Error %q doesn't report %q.", err, problem)
+        }
+    }
+}
+
+func TestConfigValidation(t *testing.T) {
+    const constant = `{"type": "ConstantComponent", "args": {"value": "c"}}`
+    tests := []struct {
+        name string
+        routes string
+        problem string
+    }{
+        {"missing slash",
+         `{"path": "api", "writer": {"type": "StringWriter"}}`,
+         `must start with "/"`},
+        {"duplicate path",
+         `{"path": "/a", "writer": {"type": "StringWriter"}},
+          {"path": "/a", "writer": {"type": "StringWriter"}}`,
+         `"/a": duplicate path`},
+        {"no workers",
+         `{"path": "/", "writer": {"type": "StringWriter"},
+           "stages": [{"type": "StaticLoadBalancer",
+                       "args": {"workers": 0},
+                       "stages": [` + constant + `]}]}`,
+         `"workers" must be positive`},
+        {"negative max workers",
+         `{"path": "/", "writer": {"type": "StringWriter"},
+           "stages": [{"type": "DynamicLoadBalancer",
+                       "args": {"maxWorkers": -1},
+                       "stages": [` + constant + `]}]}`,
+         `"maxWorkers" must be positive`},
+        {"no writer workers",
+         `{"path": "/", "writer": {"type": "StaticLoadBalancerWriter",
+            "args": {"workers": 0}, "writer": {"type": "StringWriter"}}}`,
+         `"workers" must be positive`},
+        {"no writer max workers",
+         `{"path": "/", "writer": {"type": "DynamicLoadBalancerWriter",
+            "args": {"maxWorkers": 0}, "writer": {"type": "StringWriter"}}}`,
+         `"maxWorkers" must be positive`},
+    }
+    for _, test := range tests {
+        cfg, err := mpserver.ParseConfig(
+            []byte(`{"routes": [` + test.routes + `]}`))
+        if (err != nil) {
+            t.Fatalf("%s: %v", test.name, err)
+        }
+        _, err = mpserver.DefaultRegistry.NewServer(cfg)
+        if (err == nil || !strings.Contains(err.Error(), test.problem)) {
+            t.Errorf("%s: error is %v, want it to report %s.",
+                     test.name, err, test.problem)
+        }
+    }
+}
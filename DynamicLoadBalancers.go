@@ -1,6 +1,9 @@
 package mpserver
 
-import "time"
+import (
+    "sync/atomic"
+    "time"
+)
 
 // dynamicLoadBalance starts one worker using the startWorker 
 // function. It the forwards jobs from the channel in to the 
@@ -12,13 +15,24 @@ import "time"
 // workers is smaller than maxWorkers. Similarly, when no job
 // is sent to the balancer for removeTimeout time, then it 
 // shutdowns a worker if there is a more than one worker active.
+// If workers isn't nil, the current number of workers is added 
+// to it, so that it can be monitored. The timeouts are measured
+// by the provided clock.
 func dynamicLoadBalance(in <-chan Job, toWorkers chan<- Job, 
         startWorker startFunc, shutdown shutdownFunc, 
-        addTimeout, removeTimeout time.Duration, maxWorkers int){
+        addTimeout, removeTimeout time.Duration, maxWorkers int,
+        workers *int64, clock Clock){
+    updateWorkers := func (delta int) {
+        if (workers != nil) {
+            atomic.AddInt64(workers, int64(delta))
+        }
+    }
+
     // Start the first worker
     shutdownChans := make([](chan bool), 1)
     shutdownChans[0] = make(chan bool, 1)
     go startWorker(shutdownChans[0])
+    updateWorkers(1)
 
     var job Job
     nWorkers := 1 // Current number of workers.
@@ -27,17 +41,20 @@ func dynamicLoadBalance(in <-chan Job, toWorkers chan<- Job,
     for ok {
         if (written) {
             // Read a job or remove a worker
+            timer := clock.NewTimer(removeTimeout)
             select {
                 case job, ok = <- in: {
+                    timer.Stop()
                     if (!ok) {
                         // In was close, so I need to shutdown 
                         // all workers.
+                        updateWorkers(-nWorkers)
                         shutdown(shutdownChans)
                         continue
                     }
                     written = false
                 }
-                case <- time.After(removeTimeout): {
+                case <- timer.C(): {
                     // Remove a worker if possible.
                     if (nWorkers > 1) {
                         last := shutdownChans[nWorkers-1]
@@ -45,6 +62,7 @@ func dynamicLoadBalance(in <-chan Job, toWorkers chan<- Job,
                             shutdownChans[:nWorkers-1]
                         close(last)
                         nWorkers--
+                        updateWorkers(-1)
                     }
                     continue
                 }
@@ -52,15 +70,20 @@ func dynamicLoadBalance(in <-chan Job, toWorkers chan<- Job,
         }
         
         // Try to write the current job or add a worker.
+        timer := clock.NewTimer(addTimeout)
         select {
-            case toWorkers <- job: { written = true }
-            case <- time.After(addTimeout): {
+            case toWorkers <- job: { 
+                timer.Stop()
+                written = true 
+            }
+            case <- timer.C(): {
                 // Add a worker if possible.
                 if (nWorkers < maxWorkers) {
                     shutdownChans = append(
                         shutdownChans, make(chan bool, 1))
                     go startWorker(shutdownChans[nWorkers])
                     nWorkers++
+                    updateWorkers(1)
                 }
             }
         } 
@@ -77,13 +100,22 @@ func dynamicLoadBalance(in <-chan Job, toWorkers chan<- Job,
 // one worker.
 func DynamicLoadBalancer(component Component, maxWorkers int,
              addTimeout, removeTimeout time.Duration) Component {
+    return DynamicLoadBalancerWithClock(component, maxWorkers, 
+        addTimeout, removeTimeout, SystemClock)
+}
+
+// DynamicLoadBalancerWithClock returns a DynamicLoadBalancer 
+// that measures the timeouts by the provided clock.
+func DynamicLoadBalancerWithClock(component Component, 
+        maxWorkers int, addTimeout, removeTimeout time.Duration,
+        clock Clock) Component {
     return func (in <-chan Job, out chan<- Job) {
         toWorkers := GetChan()
 
         dynamicLoadBalance(in, toWorkers, 
             startComponent(component, toWorkers, out), 
             shutdownComponents(out), addTimeout, 
-            removeTimeout, maxWorkers)
+            removeTimeout, maxWorkers, nil, clock)
     }
 }
 
@@ -97,12 +129,22 @@ func DynamicLoadBalancer(component Component, maxWorkers int,
 // one worker.
 func DynamicLoadBalancerWriter(writer Writer, maxWorkers int,
                 addTimeout, removeTimeout time.Duration) Writer {
+    return DynamicLoadBalancerWriterWithClock(writer, maxWorkers,
+        addTimeout, removeTimeout, SystemClock)
+}
+
+// DynamicLoadBalancerWriterWithClock returns a 
+// DynamicLoadBalancerWriter that measures the timeouts by the 
+// provided clock.
+func DynamicLoadBalancerWriterWithClock(writer Writer, 
+        maxWorkers int, addTimeout, removeTimeout time.Duration,
+        clock Clock) Writer {
     return func (in <-chan Job) {
         toWorkers := GetChan()
 
         dynamicLoadBalance(in, toWorkers, 
             startWriter(writer, toWorkers), 
             shutdownWriters, addTimeout, 
-            removeTimeout, maxWorkers)
+            removeTimeout, maxWorkers, nil, clock)
     }
 }
\ No newline at end of file
@@ -0,0 +1,87 @@
+package mpserver_test
+
+import (
+    "sync/atomic"
+    "testing"
+    "time"
+    "mpserver"
+    "mpserver/mpservertest"
+)
+
+func TestDynamicLoadBalancerScaling(t *testing.T) {
+    clock := mpservertest.NewFakeClock(time.Now())
+    var running int64
+    release := make(chan bool)
+    // Holds every job until it is released.
+    worker := func (in <-chan mpserver.Job, out chan<- mpserver.Job) {
+        atomic.AddInt64(&running, 1)
+        for job := range in {
+            <- release
+            out <- job
+        }
+        atomic.AddInt64(&running, -1)
+        close(out)
+    }
+    workers := func (n int64) func () bool {
+        return func () bool {
+            return atomic.LoadInt64(&running) == n
+        }
+    }
+    c := mpserver.DynamicLoadBalancerWithClock(
+        worker, 3, time.Second, time.Minute, clock)
+    in := mpserver.GetChan()
+    out := mpserver.GetChan()
+    go c(in, out)
+
+    // Every job that no worker takes within the add timeout 
+    // starts a new worker, up to the maximum.
+    for i := 0; i < 4; i++ {
+        go func () {
+            in <- mpservertest.NewGetJob()
+        }()
+    }
+    eventually(t, "First worker didn't start.", workers(1))
+    for n := int64(2); n <= 3; n++ {
+        eventually(t, "Balancer isn't waiting.", func () bool {
+            return clock.Waiters() == 1
+        })
+        clock.Advance(time.Second)
+        eventually(t, "Worker wasn't added.", workers(n))
+    }
+    clock.Advance(time.Second)
+    for i := 0; i < 4; i++ {
+        release <- true
+        <- out
+    }
+    if (!workers(3)()) {
+        t.Errorf("%d workers, want at most 3.", 
+            atomic.LoadInt64(&running))
+    }
+
+    // Idle workers are removed one by one, down to one.
+    for n := int64(2); n >= 1; n-- {
+        eventually(t, "Balancer isn't waiting.", func () bool {
+            return clock.Waiters() == 1
+        })
+        clock.Advance(time.Minute)
+        eventually(t, "Worker wasn't removed.", workers(n))
+    }
+    clock.Advance(time.Minute)
+    close(in)
+    for _ = range out {}
+    eventually(t, "Last worker wasn't stopped.", workers(0))
+}
+
+func TestDynamicLoadBalancerReleasesTimers(t *testing.T) {
+    clock := mpservertest.NewFakeClock(time.Now())
+    c := mpserver.DynamicLoadBalancerWithClock(
+        mpserver.ConstantComponent(1), 4, time.Second, time.Minute, 
+        clock)
+    if _, err := mpservertest.RunComponent(c, newJobs(20)...); err != nil {
+        t.Fatal(err)
+    }
+    // The balancer stops the timers that it gave up waiting for.
+    if (clock.Waiters() != 0) {
+        t.Errorf("%d waiters left.", clock.Waiters())
+    }
+}
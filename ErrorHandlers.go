@@ -1,7 +1,7 @@
 package mpserver
 
 import (
-    "log"
+    "fmt"
     "net/http"
     "errors"
 )
@@ -38,14 +38,36 @@ func PannicHandler(worker Component) Component {
         toComponent := GetChan()
         fromComponent := GetChan()
         shutDown := make(chan bool)
+        copied := make(chan bool)
+
+        // Set before shutDown is closed and read by the copying
+        // goroutine afterwards.
+        var panicValue interface{}
+        // Set by the copying goroutine before copied is closed.
+        var lastJob Job
+        crashReported := false
 
         // Recover from panic and restart in a new goroutine.
         defer func () {
             if r := recover(); r != nil {
-                // Shut down the copying goroutine
+                // Shut down the copying goroutine and wait for 
+                // it, so that it doesn't output a job after the
+                // new instance closed the output channel.
+                panicValue = r
                 close(shutDown); close(fromComponent)
+                <- copied
+                if (!crashReported) {
+                    // The worker crashed between jobs, so use the
+                    // logger of the pipeline of the last job.
+                    logger := DefaultLogger()
+                    if (lastJob != nil) {
+                        logger = lastJob.Logger()
+                    }
+                    logger.Error("recovered from panic",
+                        "component", "PannicHandler", 
+                        "panic", fmt.Sprint(r))
+                }
                 // Start again
-                log.Println("Recovered from:", r)
                 go phComp(in, out)
             }
         }()
@@ -56,6 +78,7 @@ func PannicHandler(worker Component) Component {
         // job this goroutine sends an error on the output 
         // channel.
         go func () {
+            defer close(copied)
             done := false
             var job Job
             var inOpen bool
@@ -73,13 +96,29 @@ func PannicHandler(worker Component) Component {
                         done = true; continue
                     }
                 }
-                toComponent <- job
-                if res, ok  := <- fromComponent; ok {
+                lastJob = job
+                res, ok := job, false
+                select {
+                    case toComponent <- job: {
+                        res, ok = <- fromComponent
+                    }
+                    case <- shutDown: {
+                        // The component crashed before it took
+                        // the job.
+                    }
+                }
+                if (ok) {
                     out <- res
                 } else {
                     // Worker panicked, so we need to report
                     // an error.
                     done = true
+                    crashReported = true
+                    job.Logger().Error("recovered from panic",
+                        "request_id", job.RequestID(), 
+                        "component", "PannicHandler", 
+                        "panic", fmt.Sprint(panicValue),
+                        "status", http.StatusInternalServerError)
                     job.SetResult(
                         errors.New("Component crashed."))
                     job.SetResponseCode(
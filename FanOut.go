@@ -0,0 +1,425 @@
+package mpserver
+
+import (
+    "errors"
+    "net/http"
+    "sync"
+    "time"
+)
+
+// BranchResult is the outcome of one branch of a FanOut for a 
+// job.
+type BranchResult struct {
+    Result interface{}  // Result field of the branch copy.
+    ResponseCode int    // Response code set by the branch.
+    Header http.Header  // Headers set by the branch.
+    Err error           // Error result, or the timeout error.
+}
+
+// MergeFunc combines the results of the branches of a FanOut, in
+// the order of the branches, into the result of the job. 
+//
+// The merge function owns the results that it is passed. Results
+// that hold resources, such as an io.ReadCloser or an 
+// *http.Response, have to be closed by it unless they are part of
+// the merged result. If it returns an error, the FanOut closes 
+// the results instead, so the merge function must not close them
+// then. Results that aren't merged, because the failure policy 
+// failed the job or the branch timed out, are closed by the 
+// FanOut.
+type MergeFunc func (job Job, results []BranchResult) (interface{}, error)
+
+// FailurePolicy decides when the results of a FanOut are merged.
+type FailurePolicy int
+
+const (
+    // RequireAll merges the results only if all branches 
+    // succeeded. Otherwise the job fails with the first error.
+    RequireAll FailurePolicy = iota
+    // RequireAny merges the results if at least one branch 
+    // succeeded.
+    RequireAny
+    // AllowPartial always merges the results, the merge function
+    // has to handle the failed branches.
+    AllowPartial
+)
+
+// fanOutConfig holds the settings of a FanOut.
+type fanOutConfig struct {
+    timeout time.Duration
+    policy FailurePolicy
+    clock Clock
+    maxJobs int
+}
+
+// FanOutOption configures a component created by FanOut.
+type FanOutOption func (config *fanOutConfig)
+
+// WithBranchTimeout returns a FanOutOption that sets the time a 
+// job waits for each branch. A branch that doesn't output its copy
+// of the job in time fails with the 504 response code.
+func WithBranchTimeout(timeout time.Duration) FanOutOption {
+    return func (config *fanOutConfig) {
+        config.timeout = timeout
+    }
+}
+
+// WithFailurePolicy returns a FanOutOption that sets the policy 
+// for failed branches. The default is RequireAll.
+func WithFailurePolicy(policy FailurePolicy) FanOutOption {
+    return func (config *fanOutConfig) {
+        config.policy = policy
+    }
+}
+
+// WithMaxJobs returns a FanOutOption that limits the number of 
+// jobs that are processed concurrently. Once the limit is 
+// reached, the FanOut doesn't read its input until one of the 
+// jobs is output. Zero, the default, and negative values mean no
+// limit.
+func WithMaxJobs(n int) FanOutOption {
+    return func (config *fanOutConfig) {
+        config.maxJobs = n
+    }
+}
+
+// WithFanOutClock returns a FanOutOption that sets the clock used
+// for the branch timeouts.
+func WithFanOutClock(clock Clock) FanOutOption {
+    return func (config *fanOutConfig) {
+        config.clock = clock
+    }
+}
+
+// errBranchTimeout is the error of a branch that timed out.
+var errBranchTimeout = errors.New("Branch timed out.")
+
+// branchJob is the copy of a job that is sent to a branch. It 
+// shares the context and attributes with the original job, but 
+// has its own copy of the request, so that branches can modify it
+// concurrently, and its own result, response code and headers. 
+// The body of the request is shared. Nothing it writes reaches 
+// the client.
+type branchJob struct {
+    Job
+    request *http.Request
+    result interface{}
+    responseCode int
+    header http.Header
+
+    // The outcome is sent on done, unless the job that the copy 
+    // belongs to stopped waiting for it. The lock guards that.
+    lock sync.Mutex
+    abandoned bool
+    done chan BranchResult
+}
+
+func newBranchJob(job Job) *branchJob {
+    return &branchJob{
+        Job: job, 
+        request: job.GetRequest().Clone(job.Context()),
+        result: job.GetResult(), 
+        responseCode: UndefinedRespCode,
+        header: make(http.Header),
+        done: make(chan BranchResult, 1),
+    }
+}
+
+func (job *branchJob) GetRequest() *http.Request {
+    return job.request
+}
+
+func (job *branchJob) GetResult() interface{} {
+    return job.result
+}
+
+func (job *branchJob) SetResult(result interface{}) {
+    job.result = result
+}
+
+func (job *branchJob) SetResponseCode(responseCode int) {
+    job.responseCode = responseCode
+}
+
+func (job *branchJob) SetResponseCodeIfUndef(responseCode int) {
+    if (job.responseCode == UndefinedRespCode) {
+        job.responseCode = responseCode
+    }
+}
+
+func (job *branchJob) SetHeader(key, value string) {
+    job.header.Set(key, value)
+}
+
+func (job *branchJob) getResponseWriter() http.ResponseWriter {
+    return discardResponseWriter{job.header}
+}
+
+func (job *branchJob) getResponseCode() int {
+    return job.responseCode
+}
+
+func (job *branchJob) writeHeader() {}
+
+func (job *branchJob) write(body []byte) {}
+
+func (job *branchJob) close() {}
+
+// deliver passes the outcome of the branch to the waiting job. If
+// the job stopped waiting, the result is released instead.
+func (job *branchJob) deliver() {
+    job.lock.Lock()
+    defer job.lock.Unlock()
+    if (job.abandoned) {
+        closeResult(job.result)
+        return
+    }
+    select {
+        case job.done <- job.outcome(): {}
+        default: {
+            // The branch output the copy twice.
+        }
+    }
+}
+
+// abandon marks the copy as no longer waited for and releases 
+// the result if it was already delivered.
+func (job *branchJob) abandon() {
+    job.lock.Lock()
+    defer job.lock.Unlock()
+    job.abandoned = true
+    select {
+        case res := <- job.done: closeResult(res.Result)
+        default: {}
+    }
+}
+
+// abort delivers the error as the outcome of the branch without 
+// touching the copy, which the branch may still hold. Whatever 
+// the branch outputs for the copy later is released.
+func (job *branchJob) abort(err error) {
+    job.lock.Lock()
+    defer job.lock.Unlock()
+    if (!job.abandoned) {
+        select {
+            case job.done <- BranchResult{Result: err, Err: err,
+                ResponseCode: http.StatusInternalServerError}: {}
+            default: {}
+        }
+    }
+    job.abandoned = true
+}
+
+// outcome returns the result of the branch for this copy.
+func (job *branchJob) outcome() BranchResult {
+    res := BranchResult{
+        Result: job.result, ResponseCode: job.responseCode,
+        Header: job.header,
+    }
+    res.Err, _ = job.result.(error)
+    return res
+}
+
+// discardResponseWriter is the response writer of a branch job.
+type discardResponseWriter struct {
+    header http.Header
+}
+
+func (w discardResponseWriter) Header() http.Header {
+    return w.header
+}
+
+func (w discardResponseWriter) WriteHeader(responseCode int) {}
+
+func (w discardResponseWriter) Write(body []byte) (int, error) {
+    return len(body), nil
+}
+
+// FanOut returns a component that sends a copy of every input job
+// to each of the branches concurrently, waits for the copies to 
+// come back and combines their results into the result of the
+// job using the merge function. Jobs are processed concurrently,
+// so the order of the output jobs may differ from the input. 
+// Every job is gathered by its own goroutine, so unless 
+// WithMaxJobs is used, the number of goroutines grows with the 
+// number of jobs waiting for slow branches.
+//
+// By default a job waits for its branches indefinitely and fails
+// if any branch fails, see WithBranchTimeout and 
+// WithFailurePolicy. A branch fails if it outputs an error 
+// result or times out. A failed job gets the error of the first
+// failed branch and its response code, or the 500 response code
+// if the branch didn't set one.
+func FanOut(merge MergeFunc, branches []Component, 
+            opts ...FanOutOption) Component {
+    config := &fanOutConfig{clock: SystemClock}
+    for _, opt := range opts {
+        opt(config)
+    }
+    return func (in <-chan Job, out chan<- Job) {
+        // Start the branches and deliver the copies they output.
+        toBranches := make([]chan Job, len(branches))
+        var collectors sync.WaitGroup
+        for i, worker := range branches {
+            toBranches[i] = GetChan()
+            fromBranch := GetChan()
+            go worker(toBranches[i], fromBranch)
+            collectors.Add(1)
+            go func () {
+                defer collectors.Done()
+                for job := range fromBranch {
+                    branch, ok := job.(*branchJob)
+                    if (!ok) {
+                        job.Logger().Error(
+                            "branch output a job it didn't receive",
+                            "request_id", job.RequestID(),
+                            "component", "FanOut")
+                        continue
+                    }
+                    branch.deliver()
+                }
+            }()
+        }
+
+        // A slot is taken before a job is read and freed once it 
+        // is output, so that at most maxJobs jobs are in progress.
+        var slots chan bool
+        if (config.maxJobs > 0) {
+            slots = make(chan bool, config.maxJobs)
+        }
+        release := func () {
+            if (slots != nil) {
+                <- slots
+            }
+        }
+        var gathers sync.WaitGroup
+        for {
+            if (slots != nil) {
+                slots <- true
+            }
+            job, ok := <- in
+            if (!ok) {
+                break
+            }
+            if (skipCancelled(job)) {
+                out <- job
+                release()
+                continue
+            }
+            gathers.Add(1)
+            go func (job Job) {
+                defer gathers.Done()
+                gather(job, toBranches, merge, config)
+                out <- job
+                release()
+            }(job)
+        }
+        gathers.Wait()
+        for _, ch := range toBranches {
+            close(ch)
+        }
+        collectors.Wait()
+        close(out)
+    }
+}
+
+// deadline returns a channel that is closed after the timeout 
+// measured by the clock, and a function that cancels the deadline
+// if it hasn't expired yet.
+func deadline(clock Clock, 
+              timeout time.Duration) (<-chan struct{}, func ()) {
+    expired := make(chan struct{})
+    cancelled := make(chan struct{})
+    timer := clock.NewTimer(timeout)
+    go func () {
+        select {
+            case <- timer.C(): close(expired)
+            case <- cancelled: timer.Stop()
+        }
+    }()
+    return expired, func () {
+        close(cancelled)
+    }
+}
+
+// gather sends copies of the job to the branches, waits for their
+// results and merges them according to the configuration.
+func gather(job Job, toBranches []chan Job, merge MergeFunc, 
+            config *fanOutConfig) {
+    // The timeout channel is closed when the timeout expires, so
+    // that it stays fired for all branches.
+    var timeout <-chan struct{}
+    if (config.timeout > 0) {
+        var cancel func ()
+        timeout, cancel = deadline(config.clock, config.timeout)
+        defer cancel()
+    }
+    timedOut := BranchResult{
+        ResponseCode: http.StatusGatewayTimeout, 
+        Err: errBranchTimeout,
+    }
+
+    copies := make([]*branchJob, len(toBranches))
+    results := make([]BranchResult, len(toBranches))
+    sent := make([]bool, len(toBranches))
+    for i, ch := range toBranches {
+        copies[i] = newBranchJob(job)
+        select {
+            case ch <- copies[i]: sent[i] = true
+            case <- timeout: results[i] = timedOut
+        }
+    }
+    for i, branch := range copies {
+        if (!sent[i]) {
+            continue
+        }
+        select {
+            case results[i] = <- branch.done: {}
+            case <- timeout: {
+                results[i] = timedOut
+                branch.abandon()
+            }
+        }
+    }
+
+    var firstErr *BranchResult
+    failed := 0
+    for i := range results {
+        if (results[i].Err != nil) {
+            failed++
+            if (firstErr == nil) {
+                firstErr = &results[i]
+            }
+        }
+    }
+    if (failed > 0 && (config.policy == RequireAll || 
+        (config.policy == RequireAny && failed == len(results)))) {
+        code := firstErr.ResponseCode
+        if (code == UndefinedRespCode) {
+            code = http.StatusInternalServerError
+        }
+        job.SetResponseCode(code)
+        job.SetResult(firstErr.Err)
+        closeResults(results)
+        return
+    }
+
+    res, err := merge(job, results)
+    if (err != nil) {
+        job.SetResponseCodeIfUndef(http.StatusInternalServerError)
+        job.SetResult(err)
+        closeResults(results)
+        return
+    }
+    job.SetResult(res)
+}
+
+// closeResults releases the results of the branches that didn't 
+// fail.
+func closeResults(results []BranchResult) {
+    for _, res := range results {
+        if (res.Err == nil) {
+            closeResult(res.Result)
+        }
+    }
+}
@@ -0,0 +1,244 @@
+package mpserver_test
+
+import (
+    "errors"
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "sync/atomic"
+    "testing"
+    "time"
+    "mpserver"
+    "mpserver/mpservertest"
+)
+
+// value returns a branch that sets the result of its jobs to the
+// provided value.
+func value(v interface{}) mpserver.Component {
+    return mpserver.ConstantComponent(v)
+}
+
+// concat merges the results of the branches into a string, with
+// "!" for the failed branches.
+func concat(job mpserver.Job, 
+            results []mpserver.BranchResult) (interface{}, error) {
+    s := ""
+    for _, res := range results {
+        if (res.Err != nil) {
+            s += "!"
+        } else {
+            s += fmt.Sprint(res.Result)
+        }
+    }
+    return s, nil
+}
+
+func TestFanOutPolicies(t *testing.T) {
+    fail := mpserver.MakeComponent(func (job mpserver.Job) {
+        job.SetResponseCode(http.StatusBadGateway)
+        job.SetResult(errors.New("Failed."))
+    })
+    tests := []struct {
+        policy mpserver.FailurePolicy
+        branches []mpserver.Component
+        result string // Empty for a failed job.
+    }{
+        {mpserver.RequireAll, []mpserver.Component{value("a"), value(1)}, "a1"},
+        {mpserver.RequireAll, []mpserver.Component{value("a"), fail}, ""},
+        {mpserver.RequireAny, []mpserver.Component{value("a"), fail}, "a!"},
+        {mpserver.RequireAny, []mpserver.Component{fail, fail}, ""},
+        {mpserver.AllowPartial, []mpserver.Component{fail, fail}, "!!"},
+    }
+    for i, test := range tests {
+        c := mpserver.FanOut(concat, test.branches, 
+            mpserver.WithFailurePolicy(test.policy))
+        for _, job := range run(t, c, newJobs(3)...) {
+            if (test.result == "") {
+                if (!isError(job)) {
+                    t.Errorf("%d: result is %v, want an error.", 
+                        i, job.GetResult())
+                }
+            } else if (job.GetResult() != test.result) {
+                t.Errorf("%d: result is %v, want %s.", 
+                    i, job.GetResult(), test.result)
+            }
+        }
+    }
+}
+
+// closer is a result that counts how many times it was closed.
+type closer struct {
+    closed *atomic.Int32
+}
+
+func (c closer) Close() error {
+    c.closed.Add(1)
+    return nil
+}
+
+func TestFanOutClosesUnmergedResults(t *testing.T) {
+    fail := mpserver.ConstantComponent(errors.New("Failed."))
+    mergeErr := func (job mpserver.Job, 
+            results []mpserver.BranchResult) (interface{}, error) {
+        return nil, errors.New("Merge failed.")
+    }
+    keep := func (job mpserver.Job, 
+            results []mpserver.BranchResult) (interface{}, error) {
+        return results, nil
+    }
+    tests := []struct {
+        name string
+        merge mpserver.MergeFunc
+        policy mpserver.FailurePolicy
+        failing bool // Whether the third branch fails.
+        closed int32
+    }{
+        {"RequireAll", keep, mpserver.RequireAll, true, 2},
+        {"merge error", mergeErr, mpserver.AllowPartial, true, 2},
+        {"merged", keep, mpserver.RequireAll, false, 0},
+    }
+    for _, test := range tests {
+        var closed atomic.Int32
+        third := value(closer{&closed})
+        if (test.failing) {
+            third = fail
+        }
+        c := mpserver.FanOut(test.merge, []mpserver.Component{
+            value(closer{&closed}), value(closer{&closed}), third,
+        }, mpserver.WithFailurePolicy(test.policy))
+        run(t, c, newJobs(1)...)
+        if n := closed.Load(); n != test.closed {
+            t.Errorf("%s: %d results were closed, want %d.", 
+                test.name, n, test.closed)
+        }
+    }
+}
+
+func TestFanOutMaxJobs(t *testing.T) {
+    received := make(chan bool)
+    release := make(chan bool)
+    // Holds every job until it is released.
+    hold := func (in <-chan mpserver.Job, out chan<- mpserver.Job) {
+        for job := range in {
+            received <- true
+            <- release
+            out <- job
+        }
+        close(out)
+    }
+    c := mpserver.FanOut(concat, []mpserver.Component{hold}, 
+        mpserver.WithMaxJobs(1))
+    in := mpserver.GetChan()
+    out := mpserver.GetChan()
+    go c(in, out)
+
+    in <- mpservertest.NewGetJob()
+    <- received
+    select {
+        case in <- mpservertest.NewGetJob(): {
+            t.Fatal("Job over the limit was read.")
+        }
+        case <- time.After(50 * time.Millisecond): {}
+    }
+    close(release)
+    go func () {
+        in <- mpservertest.NewGetJob()
+        <- received
+        close(in)
+    }()
+    n := 0
+    for _ = range out {
+        n++
+    }
+    if (n != 2) {
+        t.Errorf("Got %d jobs, want 2.", n)
+    }
+}
+
+func TestFanOutHangingBranches(t *testing.T) {
+    clock := mpservertest.NewFakeClock(time.Now())
+    release := make(chan bool)
+    // Holds every job until it is released.
+    hang := func (in <-chan mpserver.Job, out chan<- mpserver.Job) {
+        for job := range in {
+            <- release
+            out <- job
+        }
+        close(out)
+    }
+    c := mpserver.FanOut(concat, 
+        []mpserver.Component{hang, hang},
+        mpserver.WithBranchTimeout(time.Second), 
+        mpserver.WithFailurePolicy(mpserver.AllowPartial),
+        mpserver.WithFanOutClock(clock))
+    in := mpserver.GetChan()
+    out := mpserver.GetChan()
+    go c(in, out)
+
+    in <- mpservertest.NewGetJob()
+    if (!clock.BlockUntil(1, time.Second)) {
+        t.Fatal("FanOut isn't waiting for the clock.")
+    }
+    clock.Advance(time.Second)
+    select {
+        case job := <- out: {
+            if (job.GetResult() != "!!") {
+                t.Errorf("Result is %v, want !!.", job.GetResult())
+            }
+        }
+        case <- time.After(time.Second): {
+            t.Fatal("Job with hanging branches wasn't output.")
+        }
+    }
+    close(release)
+    close(in)
+    for _ = range out {}
+}
+
+func TestFanOutProxyBranches(t *testing.T) {
+    backend := func (name string) *httptest.Server {
+        return httptest.NewServer(http.HandlerFunc(
+            func (w http.ResponseWriter, r *http.Request) {
+                w.Write([]byte(name + r.URL.Path))
+            }))
+    }
+    a := backend("a")
+    defer a.Close()
+    b := backend("b")
+    defer b.Close()
+    host := func (s *httptest.Server) string {
+        return strings.TrimPrefix(s.URL, "http://")
+    }
+
+    merge := func (job mpserver.Job, 
+                   results []mpserver.BranchResult) (interface{}, error) {
+        s := ""
+        for _, res := range results {
+            s += string(res.Result.(mpserver.Response).Body)
+        }
+        return s, nil
+    }
+    // Both branches rewrite the request of the job concurrently.
+    c := mpserver.FanOut(merge, []mpserver.Component{
+        mpserver.ProxyComponent("http", host(a), http.DefaultClient),
+        mpserver.ProxyComponent("http", host(b), http.DefaultClient),
+    })
+    var jobs []mpserver.Job
+    for i := 0; i < 10; i++ {
+        job, _ := mpservertest.NewRequestJob(
+            "GET", fmt.Sprintf("/%d", i), nil)
+        jobs = append(jobs, job)
+    }
+    run(t, c, jobs...)
+    for i, job := range jobs {
+        path := fmt.Sprintf("/%d", i)
+        if want := "a" + path + "b" + path; job.GetResult() != want {
+            t.Errorf("Result is %v, want %s.", job.GetResult(), want)
+        }
+        if (job.GetRequest().URL.Host != "") {
+            t.Errorf("Request of the job was rewritten to %s.", 
+                job.GetRequest().URL)
+        }
+    }
+}
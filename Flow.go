@@ -0,0 +1,200 @@
+package mpserver
+
+import (
+    "fmt"
+    "io"
+    "net/http"
+    "os"
+    "reflect"
+    "strings"
+)
+
+// Stage is a typed step of a Flow. It takes the result of a job,
+// which has the type In, and returns the new result of type Out 
+// or an error. Stages are not called for jobs that already 
+// contain an error or whose client has gone away.
+type Stage[In, Out any] func (job Job, in In) (Out, error)
+
+// Flow is a pipeline under construction, whose jobs carry a 
+// result of type T after its last step. Steps are added by the 
+// Then and ThenComponent functions, which check at compile time 
+// that the result type of a step matches the input type of the 
+// next one. The finished flow is turned into an ordinary 
+// Component or Writer.
+//
+// Flows are immutable, adding a step returns a new Flow, so a 
+// flow can be used as a prefix of several pipelines.
+type Flow[T any] struct {
+    components []Component
+}
+
+// NewFlow returns a flow that starts with the request of the job
+// in the result field.
+func NewFlow() *Flow[*http.Request] {
+    return FlowFrom[*http.Request](MakeComponent(func (job Job) {
+        job.SetResult(job.GetRequest())
+    }))
+}
+
+// FlowFrom returns a flow that starts with the provided untyped 
+// component, which is declared to output jobs with a result of 
+// type T.
+func FlowFrom[T any](c Component) *Flow[T] {
+    return &Flow[T]{[]Component{c}}
+}
+
+// then returns a flow with the component appended to the steps 
+// of the provided flow.
+func then[In, Out any](f *Flow[In], c Component) *Flow[Out] {
+    n := len(f.components)
+    return &Flow[Out]{append(f.components[:n:n], c)}
+}
+
+// Then returns a flow that extends the provided flow with the 
+// stage.
+func Then[In, Out any](f *Flow[In], stage Stage[In, Out]) *Flow[Out] {
+    return then[In, Out](f, stage.Component())
+}
+
+// ThenComponent returns a flow that extends the provided flow 
+// with an untyped component, which is declared to take jobs with
+// a result of type In and output jobs with a result of type Out.
+// Jobs with an error result bypass the component. The declaration
+// is checked at runtime by the next stage of the flow.
+func ThenComponent[In, Out any](f *Flow[In], c Component) *Flow[Out] {
+    return then[In, Out](f, ErrorPasser(c))
+}
+
+// Apply returns a flow that extends the flow with a stage that 
+// doesn't change the type of the result.
+func (f *Flow[T]) Apply(stage Stage[T, T]) *Flow[T] {
+    return Then(f, stage)
+}
+
+// Component returns a component that runs the steps of the flow.
+func (f *Flow[T]) Component() Component {
+    return LinkComponents(f.components...)
+}
+
+// WriteTo returns a writer that runs the steps of the flow and 
+// writes the results using the sink. Jobs with an error in the 
+// result field are written by the ErrorWriter.
+func (f *Flow[T]) WriteTo(sink Sink[T]) Writer {
+    return f.WriteToWithErrors(sink, ErrorWriter)
+}
+
+// WriteToWithErrors behaves like WriteTo, but writes the jobs with
+// an error in the result field using errWriter.
+func (f *Flow[T]) WriteToWithErrors(sink Sink[T], 
+                                    errWriter Writer) Writer {
+    return SplitErrors(f.components, sink.writer, errWriter)
+}
+
+// typeName returns the name of the type T.
+func typeName[T any]() string {
+    return reflect.TypeOf((*T)(nil)).Elem().String()
+}
+
+// Component returns a component that replaces the result of 
+// every input job with the output of the stage. If the result of
+// a job doesn't have the type In, the result is replaced with an
+// error and the 500 response code is set.
+func (stage Stage[In, Out]) Component() Component {
+    return func (in <-chan Job, out chan<- Job) {
+        for job := range in {
+            if _, isErr := job.GetResult().(error); isErr || 
+                skipCancelled(job) {
+                out <- job
+                continue
+            }
+            value, ok := job.GetResult().(In)
+            if (!ok) {
+                job.SetResponseCode(http.StatusInternalServerError)
+                job.SetResult(fmt.Errorf(
+                    "Stage expected a result of type %s, got %T.",
+                    typeName[In](), job.GetResult()))
+                out <- job
+                continue
+            }
+            res, err := stage(job, value)
+            if (err != nil) {
+                job.SetResult(err)
+            } else {
+                job.SetResult(res)
+            }
+            out <- job
+        }
+        close(out)
+    }
+}
+
+// Sink is a Writer that is declared to accept jobs with a result
+// of type T. It ends a Flow with a matching result type.
+type Sink[T any] struct {
+    writer Writer
+}
+
+// SinkOf declares that the writer accepts jobs with a result of 
+// type T.
+func SinkOf[T any](writer Writer) Sink[T] {
+    return Sink[T]{writer}
+}
+
+// Writer returns the writer of the sink.
+func (s Sink[T]) Writer() Writer {
+    return s.writer
+}
+
+// Sinks of the writers provided by this package.
+var (
+    StringSink = SinkOf[string](StringWriter)
+    GenericSink = SinkOf[io.ReadCloser](GenericWriter)
+    GzipSink = SinkOf[io.ReadCloser](GzipWriter)
+    ResponseSink = SinkOf[Response](ResponseWriter)
+    HttpResponseSink = SinkOf[*http.Response](HttpResponseWriter)
+)
+
+// JsonSink returns the sink of the JsonWriter for results of 
+// type T.
+func JsonSink[T any]() Sink[T] {
+    return SinkOf[T](JsonWriter)
+}
+
+// PathStage returns a stage that behaves like the PathMaker, it 
+// strips the prefix from the URL path of the request and 
+// prepends the directory path to it.
+func PathStage(dir, prefix string) Stage[*http.Request, string] {
+    return func (job Job, r *http.Request) (string, error) {
+        return dir + strings.TrimPrefix(r.URL.Path, prefix), nil
+    }
+}
+
+// FileStage is a stage that behaves like the FileComponent, it 
+// opens the file with the provided path. 
+func FileStage(job Job, path string) (io.ReadCloser, error) {
+    file, err := os.Open(path)
+    if (err != nil) {
+        job.SetResponseCode(http.StatusBadRequest)
+        return nil, err
+    }
+    return file, nil
+}
+
+// FetchStage returns a stage that behaves like the 
+// NetworkComponent, it performs the request using the client.
+func FetchStage(client *http.Client) Stage[*http.Request, *http.Response] {
+    return func (job Job, req *http.Request) (*http.Response, error) {
+        return doRequest(client, job, req)
+    }
+}
+
+// ReadResponseStage is a stage that behaves like the 
+// ResponseReader, it reads the body of the response.
+func ReadResponseStage(job Job, resp *http.Response) (Response, error) {
+    body, err := readResponse(resp)
+    if (err != nil) {
+        return Response{}, err
+    }
+    job.SetResponseCode(resp.StatusCode)
+    return Response{resp.Header, resp.StatusCode, body}, nil
+}
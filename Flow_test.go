@@ -0,0 +1,92 @@
+package mpserver_test
+
+import (
+    "errors"
+    "net/http"
+    "strings"
+    "testing"
+    "mpserver"
+    "mpserver/mpservertest"
+)
+
+// pathFlow is a flow that turns the URL path of the request into
+// its length.
+var pathFlow = mpserver.Then(
+    mpserver.Then(mpserver.NewFlow(), mpserver.PathStage("", "/")),
+    func (job mpserver.Job, path string) (int, error) {
+        if (path == "fail") {
+            job.SetResponseCode(http.StatusBadRequest)
+            return 0, errors.New("Bad path.")
+        }
+        return len(path), nil
+    })
+
+// repeat is a stage that returns a string of n stars.
+func repeat(job mpserver.Job, n int) (string, error) {
+    return strings.Repeat("*", n), nil
+}
+
+func TestFlowSink(t *testing.T) {
+    w := mpserver.Then(pathFlow, repeat).WriteTo(mpserver.StringSink)
+    job, rec := mpservertest.NewRequestJob("GET", "/abc", nil)
+    if err := mpservertest.RunWriter(w, job); err != nil {
+        t.Fatal(err)
+    }
+    expect(t, rec, http.StatusOK, "***")
+
+    // The error of a stage skips the following stages and is
+    // written by the ErrorWriter.
+    job, rec = mpservertest.NewRequestJob("GET", "/fail", nil)
+    if err := mpservertest.RunWriter(w, job); err != nil {
+        t.Fatal(err)
+    }
+    expect(t, rec, http.StatusBadRequest, "Bad path.\n")
+}
+
+func TestFlowChaining(t *testing.T) {
+    // Flows are immutable, so both flows share the prefix.
+    double := pathFlow.Apply(func (job mpserver.Job, n int) (int, error) {
+        return 2 * n, nil
+    })
+    for _, test := range []struct {
+        flow *mpserver.Flow[int]
+        want int
+    }{{pathFlow, 3}, {double, 6}} {
+        job, _ := mpservertest.NewRequestJob("GET", "/abc", nil)
+        _, err := mpservertest.RunComponent(test.flow.Component(), job)
+        if (err != nil) {
+            t.Fatal(err)
+        }
+        if (job.GetResult() != test.want) {
+            t.Errorf("Result is %v, want %d.",
+                     job.GetResult(), test.want)
+        }
+    }
+}
+
+func TestFlowWrongType(t *testing.T) {
+    // The component is declared to output strings, but outputs
+    // an int.
+    called := false
+    f := mpserver.FlowFrom[string](mpserver.ConstantComponent(1))
+    f = f.Apply(func (job mpserver.Job, s string) (string, error) {
+        called = true
+        return s, nil
+    })
+    job, rec := mpservertest.NewRequestJob("GET", "/", nil)
+    _, err := mpservertest.RunComponent(f.Component(), job)
+    if (err != nil) {
+        t.Fatal(err)
+    }
+    if (called) {
+        t.Error("Stage was called with a result of a wrong type.")
+    }
+    if (!isError(job)) {
+        t.Fatalf("Result is %v, want an error.", job.GetResult())
+    }
+    if err := mpservertest.RunWriter(mpserver.ErrorWriter, job); err != nil {
+        t.Fatal(err)
+    }
+    expect(t, rec, http.StatusInternalServerError,
+           "Stage expected a result of type string, got int.\n")
+}
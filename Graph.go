@@ -0,0 +1,505 @@
+package mpserver
+
+import (
+    "errors"
+    "fmt"
+    "net/http"
+    "sort"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+// nodeKind is the kind of a node of a Graph.
+type nodeKind string
+
+const (
+    componentNode nodeKind = "Component"
+    routerNode nodeKind = "Router"
+    errorRouterNode nodeKind = "ErrorRouter"
+    collectorNode nodeKind = "Collector"
+    writerNode nodeKind = "Writer"
+    staticBalancerNode nodeKind = "StaticLoadBalancer"
+    dynamicBalancerNode nodeKind = "DynamicLoadBalancer"
+)
+
+// graphNode is a node of a Graph. It reads jobs from the edges in
+// ins and writes them to the edges in outs.
+type graphNode struct {
+    name string
+    kind nodeKind
+    ins []string
+    outs []string
+    conds []Condition // Conditions of a Router for outs[1:].
+    run func (ins []<-chan Job, outs []chan<- Job)
+
+    // Current and maximum number of workers of load balancers.
+    workers int64
+    maxWorkers int
+}
+
+// Graph is a builder for pipelines. Its nodes are Components, 
+// Routers, Collectors and Writers, which are connected by edges
+// that are declared by name. An edge is a channel, so it must 
+// have exactly one node writing to it and one node reading from
+// it. Input edges are fed from outside of the graph, typically
+// by a handler. The graph is validated before any goroutines are
+// started, so that wiring mistakes are reported as errors 
+// instead of deadlocking the server.
+type Graph struct {
+    nodes []*graphNode
+    names map[string]bool
+    inputs []string
+    errs []error
+    clock Clock
+
+    // Number of jobs that passed through each edge, only tracked
+    // if counting is enabled.
+    counting bool
+    countsLock sync.Mutex
+    counts map[string]*uint64
+}
+
+// GraphOption configures a Graph created by NewGraph.
+type GraphOption func (g *Graph)
+
+// WithGraphClock returns a GraphOption that sets the clock used 
+// by the timed nodes of the graph, such as DynamicLoadBalancers.
+// It is SystemClock by default. A fake clock makes the scaling of
+// the load balancers deterministic in tests.
+func WithGraphClock(clock Clock) GraphOption {
+    return func (g *Graph) {
+        g.clock = clock
+    }
+}
+
+// WithJobCounts returns a GraphOption that makes the pipelines 
+// built from the graph count the jobs that pass through each 
+// edge, so that they are reported by Topology, DOT and 
+// DebugHandler. Counting adds a goroutine and a hop to every 
+// edge, so it is disabled by default.
+func WithJobCounts() GraphOption {
+    return func (g *Graph) {
+        g.counting = true
+    }
+}
+
+// NewGraph returns an empty Graph configured with the provided 
+// options.
+func NewGraph(opts ...GraphOption) *Graph {
+    g := &Graph{
+        names: make(map[string]bool), 
+        clock: SystemClock,
+        counts: make(map[string]*uint64),
+    }
+    for _, opt := range opts {
+        opt(g)
+    }
+    return g
+}
+
+// addNode adds the node to the graph and records an error if a 
+// node with the same name already exists.
+func (g *Graph) addNode(node *graphNode) *Graph {
+    if (g.names[node.name]) {
+        g.errs = append(g.errs, fmt.Errorf(
+            "Duplicate node name %q.", node.name))
+    }
+    g.names[node.name] = true
+    g.nodes = append(g.nodes, node)
+    return g
+}
+
+// Input declares an input edge of the graph, that is an edge 
+// that is fed from outside of the graph.
+func (g *Graph) Input(edge string) *Graph {
+    g.inputs = append(g.inputs, edge)
+    return g
+}
+
+// Component adds a node that runs the provided component with
+// the in edge as its input channel and the out edge as its 
+// output channel.
+func (g *Graph) Component(name string, c Component, 
+                          in, out string) *Graph {
+    return g.addNode(&graphNode{
+        name: name, kind: componentNode,
+        ins: []string{in}, outs: []string{out},
+        run: func (ins []<-chan Job, outs []chan<- Job) {
+            c(ins[0], outs[0])
+        },
+    })
+}
+
+// Router adds a node that runs the Router with the provided 
+// edges and conditions. The number of output edges and the 
+// number of conditions should be the same.
+func (g *Graph) Router(name string, in, defOut string, 
+                       outs []string, conds []Condition) *Graph {
+    return g.addNode(&graphNode{
+        name: name, kind: routerNode,
+        ins: []string{in}, 
+        outs: append([]string{defOut}, outs...),
+        conds: conds,
+        run: func (ins []<-chan Job, outs []chan<- Job) {
+            Router(ins[0], outs[0], outs[1:], conds)
+        },
+    })
+}
+
+// ErrorRouter adds a node that runs the ErrorRouter with the 
+// provided edges.
+func (g *Graph) ErrorRouter(name string, 
+                            in, defOut, errOut string) *Graph {
+    return g.addNode(&graphNode{
+        name: name, kind: errorRouterNode,
+        ins: []string{in}, outs: []string{defOut, errOut},
+        run: func (ins []<-chan Job, outs []chan<- Job) {
+            ErrorRouter(ins[0], outs[0], outs[1])
+        },
+    })
+}
+
+// Collector adds a node that runs the Collector with the 
+// provided edges.
+func (g *Graph) Collector(name string, ins []string, 
+                          out string) *Graph {
+    return g.addNode(&graphNode{
+        name: name, kind: collectorNode,
+        ins: ins, outs: []string{out},
+        run: func (ins []<-chan Job, outs []chan<- Job) {
+            Collector(ins, outs[0])
+        },
+    })
+}
+
+// StaticLoadBalancer adds a node that runs a StaticLoadBalancer 
+// with nWorkers instances of the provided worker.
+func (g *Graph) StaticLoadBalancer(name string, worker Component, 
+        nWorkers int, in, out string) *Graph {
+    node := &graphNode{
+        name: name, kind: staticBalancerNode,
+        ins: []string{in}, outs: []string{out},
+        maxWorkers: nWorkers,
+    }
+    node.run = func (ins []<-chan Job, outs []chan<- Job) {
+        atomic.AddInt64(&node.workers, int64(nWorkers))
+        defer atomic.AddInt64(&node.workers, -int64(nWorkers))
+        StaticLoadBalancer(worker, nWorkers)(ins[0], outs[0])
+    }
+    return g.addNode(node)
+}
+
+// DynamicLoadBalancer adds a node that runs a DynamicLoadBalancer
+// with the provided worker and settings. The current number of 
+// workers is reported in the topology of the graph.
+func (g *Graph) DynamicLoadBalancer(name string, worker Component, 
+        maxWorkers int, addTimeout, removeTimeout time.Duration, 
+        in, out string) *Graph {
+    node := &graphNode{
+        name: name, kind: dynamicBalancerNode,
+        ins: []string{in}, outs: []string{out},
+        maxWorkers: maxWorkers,
+    }
+    node.run = func (ins []<-chan Job, outs []chan<- Job) {
+        toWorkers := GetChan()
+        dynamicLoadBalance(ins[0], toWorkers, 
+            startComponent(worker, toWorkers, outs[0]), 
+            shutdownComponents(outs[0]), addTimeout, 
+            removeTimeout, maxWorkers, &node.workers, g.clock)
+    }
+    return g.addNode(node)
+}
+
+// Writer adds a node that runs the provided writer with the in 
+// edge as its input channel.
+func (g *Graph) Writer(name string, w Writer, in string) *Graph {
+    return g.addNode(&graphNode{
+        name: name, kind: writerNode,
+        ins: []string{in},
+        run: func (ins []<-chan Job, outs []chan<- Job) {
+            w(ins[0])
+        },
+    })
+}
+
+// Validate checks that the graph is wired correctly. That is 
+// every edge has exactly one producer and one consumer, every
+// Router has a condition for each of its output edges, there are
+// no cycles and hence every path from an input ends in a Writer.
+func (g *Graph) Validate() error {
+    errs := append([]error{}, g.errs...)
+    if (len(g.inputs) == 0) {
+        errs = append(errs, errors.New("Graph has no input edges."))
+    }
+
+    producers := make(map[string][]string)
+    consumers := make(map[string][]string)
+    for _, edge := range g.inputs {
+        producers[edge] = append(producers[edge], "input")
+    }
+    for _, node := range g.nodes {
+        for _, edge := range node.ins {
+            consumers[edge] = append(consumers[edge], node.name)
+        }
+        for _, edge := range node.outs {
+            producers[edge] = append(producers[edge], node.name)
+        }
+        if (node.kind == routerNode) {
+            if (len(node.outs)-1 != len(node.conds)) {
+                errs = append(errs, fmt.Errorf(
+                    "Router %q has %d output edges and %d conditions.",
+                    node.name, len(node.outs)-1, len(node.conds)))
+            }
+            for i, cond := range node.conds {
+                if (cond == nil) {
+                    errs = append(errs, fmt.Errorf(
+                        "Router %q has a nil condition at %d.",
+                        node.name, i))
+                }
+            }
+        }
+        if (node.kind == collectorNode && len(node.ins) == 0) {
+            errs = append(errs, fmt.Errorf(
+                "Collector %q has no input edges.", node.name))
+        }
+    }
+
+    edges := make(map[string]bool)
+    for edge := range producers {
+        edges[edge] = true
+    }
+    for edge := range consumers {
+        edges[edge] = true
+    }
+    for _, edge := range sortedKeys(edges) {
+        if (edge == "") {
+            errs = append(errs, errors.New("Edge with empty name."))
+            continue
+        }
+        if (len(producers[edge]) != 1) {
+            errs = append(errs, fmt.Errorf(
+                "Edge %q has %d producers %v, expected 1.", 
+                edge, len(producers[edge]), producers[edge]))
+        }
+        if (len(consumers[edge]) != 1) {
+            errs = append(errs, fmt.Errorf(
+                "Edge %q has %d consumers %v, expected 1.", 
+                edge, len(consumers[edge]), consumers[edge]))
+        }
+    }
+
+    if (len(errs) == 0) {
+        if err := g.checkAcyclic(); err != nil {
+            errs = append(errs, err)
+        }
+    }
+    return errors.Join(errs...)
+}
+
+// checkAcyclic checks that the graph doesn't contain a cycle, 
+// by removing nodes whose input edges are all produced by 
+// removed nodes or inputs.
+func (g *Graph) checkAcyclic() error {
+    ready := make(map[string]bool)
+    for _, edge := range g.inputs {
+        ready[edge] = true
+    }
+    remaining := g.nodes
+    for len(remaining) > 0 {
+        var blocked []*graphNode
+        for _, node := range remaining {
+            runnable := true
+            for _, edge := range node.ins {
+                runnable = runnable && ready[edge]
+            }
+            if (runnable) {
+                for _, edge := range node.outs {
+                    ready[edge] = true
+                }
+            } else {
+                blocked = append(blocked, node)
+            }
+        }
+        if (len(blocked) == len(remaining)) {
+            names := make([]string, len(blocked))
+            for i, node := range blocked {
+                names[i] = node.name
+            }
+            return fmt.Errorf("Nodes %v form a cycle.", names)
+        }
+        remaining = blocked
+    }
+    return nil
+}
+
+// sortedKeys returns the keys of the map in sorted order.
+func sortedKeys(m map[string]bool) []string {
+    keys := make([]string, 0, len(m))
+    for key := range m {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+    return keys
+}
+
+// counter returns the job counter of the provided edge.
+func (g *Graph) counter(edge string) *uint64 {
+    g.countsLock.Lock()
+    defer g.countsLock.Unlock()
+    if _, ok := g.counts[edge]; !ok {
+        g.counts[edge] = new(uint64)
+    }
+    return g.counts[edge]
+}
+
+// countJobs forwards jobs from in to out and counts them. It 
+// closes out when in is closed.
+func countJobs(in <-chan Job, out chan<- Job, counter *uint64) {
+    for job := range in {
+        atomic.AddUint64(counter, 1)
+        out <- job
+    }
+    close(out)
+}
+
+// start creates the channels for all edges, using the provided
+// channels for the input edges, and starts all nodes. If 
+// counting is enabled, the jobs that pass through each edge are
+// counted. The returned wait group finishes when all Writers 
+// terminate.
+func (g *Graph) start(inputs map[string]chan Job) *sync.WaitGroup {
+    // Producers write to the first channel of an edge and 
+    // consumers read from the second one.
+    type edgeChans struct {
+        from chan Job
+        to chan Job
+    }
+    chans := make(map[string]edgeChans)
+    channels := func (edge string) edgeChans {
+        if _, ok := chans[edge]; !ok {
+            from, ok := inputs[edge]
+            if (!ok) {
+                from = GetChan()
+            }
+            to := from
+            if (g.counting) {
+                to = GetChan()
+                go countJobs(from, to, g.counter(edge))
+            }
+            chans[edge] = edgeChans{from, to}
+        }
+        return chans[edge]
+    }
+
+    writers := &sync.WaitGroup{}
+    for _, node := range g.nodes {
+        ins := make([]<-chan Job, len(node.ins))
+        for i, edge := range node.ins {
+            ins[i] = channels(edge).to
+        }
+        outs := make([]chan<- Job, len(node.outs))
+        for i, edge := range node.outs {
+            outs[i] = channels(edge).from
+        }
+        if (node.kind == writerNode) {
+            writers.Add(1)
+            go func (node *graphNode) {
+                defer writers.Done()
+                node.run(ins, outs)
+            }(node)
+        } else {
+            go node.run(ins, outs)
+        }
+    }
+    return writers
+}
+
+// Pipeline is a running pipeline built from a Graph.
+type Pipeline struct {
+    graph *Graph
+    inputs map[string]chan Job
+    writers *sync.WaitGroup
+    closeOnce sync.Once
+}
+
+// Build validates the graph and starts all of its nodes. It 
+// returns an error, without starting anything, if the graph 
+// isn't wired correctly.
+func (g *Graph) Build() (*Pipeline, error) {
+    if err := g.Validate(); err != nil {
+        return nil, err
+    }
+    p := &Pipeline{graph: g, inputs: make(map[string]chan Job)}
+    for _, edge := range g.inputs {
+        p.inputs[edge] = GetChan()
+    }
+    p.writers = g.start(p.inputs)
+    return p, nil
+}
+
+// BuildWriter validates the graph, which must have exactly one 
+// input edge, and returns a Writer that starts all nodes of the 
+// graph with its input channel as the input edge. The Writer 
+// terminates when all Writers of the graph terminate, so it can
+// be used with load balancers and Server.Route like any other 
+// Writer.
+func (g *Graph) BuildWriter() (Writer, error) {
+    if err := g.Validate(); err != nil {
+        return nil, err
+    }
+    if (len(g.inputs) != 1) {
+        return nil, fmt.Errorf(
+            "Graph has %d input edges, expected 1.", len(g.inputs))
+    }
+    input := g.inputs[0]
+    return func (in <-chan Job) {
+        // Forward the jobs, as the graph needs a bidirectional 
+        // channel for the input edge.
+        ch := GetChan()
+        writers := g.start(map[string]chan Job{input: ch})
+        for job := range in {
+            ch <- job
+        }
+        close(ch)
+        writers.Wait()
+    }, nil
+}
+
+// Input returns the channel of the provided input edge or nil if
+// there is no such input edge.
+func (p *Pipeline) Input(edge string) chan<- Job {
+    if ch, ok := p.inputs[edge]; ok {
+        return ch
+    }
+    return nil
+}
+
+// Handler returns an http.Handler that sends jobs to the 
+// provided input edge. An error is returned if there is no such
+// input edge, as the handler would block forever.
+func (p *Pipeline) Handler(edge string, 
+                           opts ...HandlerOption) (http.Handler, error) {
+    in, ok := p.inputs[edge]
+    if (!ok) {
+        return nil, fmt.Errorf("Pipeline has no input edge %q.", edge)
+    }
+    return Handler(in, opts...), nil
+}
+
+// Close closes all input edges of the pipeline, so that all of 
+// its nodes terminate after processing the jobs that they have 
+// already received. No jobs can be sent to the pipeline after 
+// it was closed.
+func (p *Pipeline) Close() {
+    p.closeOnce.Do(func () {
+        for _, ch := range p.inputs {
+            close(ch)
+        }
+    })
+}
+
+// Wait waits until all Writers of the pipeline terminate.
+func (p *Pipeline) Wait() {
+    p.writers.Wait()
+}
@@ -0,0 +1,195 @@
+package mpserver
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "reflect"
+    "runtime"
+    "strings"
+    "sync/atomic"
+)
+
+// NodeInfo describes a node of a Graph.
+type NodeInfo struct {
+    Name string `json:"name"`
+    Kind string `json:"kind"`
+    Ins []string `json:"ins,omitempty"`
+    Outs []string `json:"outs,omitempty"`
+
+    // Conditions contains the names of the conditions of a 
+    // Router. The i-th condition leads to the output edge 
+    // Outs[i+1], while Outs[0] is the default output edge.
+    Conditions []string `json:"conditions,omitempty"`
+
+    // Current and maximum number of workers of a load balancer.
+    Workers int64 `json:"workers,omitempty"`
+    MaxWorkers int `json:"maxWorkers,omitempty"`
+}
+
+// EdgeInfo describes an edge of a Graph.
+type EdgeInfo struct {
+    Name string `json:"name"`
+    From string `json:"from"` // Producer node, empty for inputs.
+    To string `json:"to"`
+    // Jobs that passed through so far, if the graph counts them.
+    Jobs uint64 `json:"jobs"`
+}
+
+// Topology describes the nodes and edges of a Graph together 
+// with live statistics of the running pipelines built from it.
+type Topology struct {
+    Inputs []string `json:"inputs"`
+    Counted bool `json:"counted"` // Whether jobs are counted.
+    Nodes []NodeInfo `json:"nodes"`
+    Edges []EdgeInfo `json:"edges"`
+}
+
+// funcName returns the name of the provided function.
+func funcName(f interface{}) string {
+    fn := runtime.FuncForPC(reflect.ValueOf(f).Pointer())
+    if (fn == nil) {
+        return "?"
+    }
+    return fn.Name()
+}
+
+// Topology returns the current topology of the graph. The job 
+// counts and numbers of workers are summed over all pipelines 
+// started from the graph. Jobs are only counted if the graph was
+// created with WithJobCounts.
+func (g *Graph) Topology() Topology {
+    topology := Topology{Inputs: g.inputs, Counted: g.counting}
+    producers := make(map[string]string)
+    consumers := make(map[string]string)
+    edges := make(map[string]bool)
+    for _, edge := range g.inputs {
+        edges[edge] = true
+    }
+
+    for _, node := range g.nodes {
+        info := NodeInfo{
+            Name: node.name, Kind: string(node.kind),
+            Ins: node.ins, Outs: node.outs,
+            Workers: atomic.LoadInt64(&node.workers),
+            MaxWorkers: node.maxWorkers,
+        }
+        for _, cond := range node.conds {
+            info.Conditions = append(info.Conditions, funcName(cond))
+        }
+        topology.Nodes = append(topology.Nodes, info)
+
+        for _, edge := range node.ins {
+            consumers[edge] = node.name
+            edges[edge] = true
+        }
+        for _, edge := range node.outs {
+            producers[edge] = node.name
+            edges[edge] = true
+        }
+    }
+
+    for _, edge := range sortedKeys(edges) {
+        info := EdgeInfo{
+            Name: edge, From: producers[edge], To: consumers[edge],
+        }
+        if (g.counting) {
+            info.Jobs = atomic.LoadUint64(g.counter(edge))
+        }
+        topology.Edges = append(topology.Edges, info)
+    }
+    return topology
+}
+
+// nodeShapes contains the Graphviz shapes of the node kinds.
+var nodeShapes = map[nodeKind]string{
+    componentNode: "box",
+    routerNode: "diamond",
+    errorRouterNode: "diamond",
+    collectorNode: "invtriangle",
+    writerNode: "doubleoctagon",
+    staticBalancerNode: "box3d",
+    dynamicBalancerNode: "box3d",
+}
+
+// DOT returns the topology of the graph in the DOT language of 
+// Graphviz. Edges are labelled with their names, the conditions
+// of Routers and the number of jobs that passed through them, if
+// jobs are counted.
+func (g *Graph) DOT() string {
+    topology := g.Topology()
+    var b strings.Builder
+    b.WriteString("digraph pipeline {\n    rankdir=LR;\n")
+
+    for _, edge := range topology.Inputs {
+        fmt.Fprintf(&b, "    %q [shape=point];\n", "input:"+edge)
+    }
+    labels := make(map[string]string)
+    for _, node := range topology.Nodes {
+        label := node.Name + "\n" + node.Kind
+        if (node.MaxWorkers > 0) {
+            label += fmt.Sprintf("\nworkers: %d/%d", 
+                node.Workers, node.MaxWorkers)
+        }
+        fmt.Fprintf(&b, "    %q [shape=%s, label=%q];\n", 
+            node.Name, nodeShapes[nodeKind(node.Kind)], label)
+
+        if (node.Kind == string(routerNode)) {
+            labels[node.Outs[0]] = "default"
+            for i, cond := range node.Conditions {
+                if (i+1 < len(node.Outs)) {
+                    labels[node.Outs[i+1]] = cond
+                }
+            }
+        }
+        if (node.Kind == string(errorRouterNode)) {
+            labels[node.Outs[1]] = "error"
+        }
+    }
+
+    for _, edge := range topology.Edges {
+        from := edge.From
+        if (from == "") {
+            from = "input:" + edge.Name
+        }
+        label := edge.Name
+        if (topology.Counted) {
+            label = fmt.Sprintf("%s (%d)", edge.Name, edge.Jobs)
+        }
+        if cond, ok := labels[edge.Name]; ok {
+            label = cond + "\n" + label
+        }
+        fmt.Fprintf(&b, "    %q -> %q [label=%q];\n", 
+            from, edge.To, label)
+    }
+    b.WriteString("}\n")
+    return b.String()
+}
+
+// DebugHandler returns an http.Handler that serves the live 
+// topology of the graph as JSON, or as DOT if the format query
+// parameter is set to dot.
+func (g *Graph) DebugHandler() http.Handler {
+    return http.HandlerFunc(func (w http.ResponseWriter, 
+                                  r *http.Request) {
+        if (r.URL.Query().Get("format") == "dot") {
+            w.Header().Set("Content-Type", "text/vnd.graphviz")
+            w.Write([]byte(g.DOT()))
+            return
+        }
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(g.Topology())
+    })
+}
+
+// Topology returns the current topology of the graph that the 
+// pipeline was built from.
+func (p *Pipeline) Topology() Topology {
+    return p.graph.Topology()
+}
+
+// DOT returns the topology of the graph that the pipeline was 
+// built from in the DOT language of Graphviz.
+func (p *Pipeline) DOT() string {
+    return p.graph.DOT()
+}
@@ -0,0 +1,117 @@
+package mpserver_test
+
+import (
+    "encoding/json"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "mpserver"
+)
+
+// serveTestGraph builds the test graph with the provided options,
+// serves a good and a failing request with it and closes it.
+func serveTestGraph(t *testing.T,
+                    opts ...mpserver.GraphOption) *mpserver.Graph {
+    t.Helper()
+    g := newTestGraph(opts...)
+    p, err := g.Build()
+    if (err != nil) {
+        t.Fatal(err)
+    }
+    h, err := p.Handler("in")
+    if (err != nil) {
+        t.Fatal(err)
+    }
+    for _, path := range []string{"/", "/fail"} {
+        h.ServeHTTP(httptest.NewRecorder(),
+                    httptest.NewRequest("GET", path, nil))
+    }
+    p.Close()
+    p.Wait()
+    return g
+}
+
+func TestGraphTopology(t *testing.T) {
+    topology := serveTestGraph(t, mpserver.WithJobCounts()).Topology()
+    if (!topology.Counted) {
+        t.Error("Jobs aren't counted.")
+    }
+    if (len(topology.Nodes) != 4) {
+        t.Fatalf("Topology has %d nodes, want 4.", len(topology.Nodes))
+    }
+    router := topology.Nodes[1]
+    if (router.Kind != "Router" || len(router.Conditions) != 1 ||
+        !strings.HasSuffix(router.Conditions[0], "isError")) {
+        t.Errorf("Router is described as %+v.", router)
+    }
+
+    want := map[string]mpserver.EdgeInfo{
+        "in": {"in", "", "work", 2},
+        "toRouter": {"toRouter", "work", "router", 2},
+        "good": {"good", "router", "strings", 1},
+        "bad": {"bad", "router", "errors", 1},
+    }
+    if (len(topology.Edges) != len(want)) {
+        t.Errorf("Topology has %d edges, want %d.",
+                 len(topology.Edges), len(want))
+    }
+    for _, edge := range topology.Edges {
+        if (edge != want[edge.Name]) {
+            t.Errorf("Edge is %+v, want %+v.", edge, want[edge.Name])
+        }
+    }
+}
+
+func TestGraphTopologyWithoutCounts(t *testing.T) {
+    g := serveTestGraph(t)
+    topology := g.Topology()
+    if (topology.Counted) {
+        t.Error("Jobs are counted by default.")
+    }
+    for _, edge := range topology.Edges {
+        if (edge.Jobs != 0) {
+            t.Errorf("Edge %q counted %d jobs.", edge.Name, edge.Jobs)
+        }
+    }
+    if (strings.Contains(g.DOT(), "(0)")) {
+        t.Error("DOT contains job counts.")
+    }
+}
+
+func TestGraphDOT(t *testing.T) {
+    dot := serveTestGraph(t, mpserver.WithJobCounts()).DOT()
+    for _, line := range []string{
+        "digraph pipeline {",
+        `"input:in" [shape=point];`,
+        `"router" [shape=diamond, label="router\nRouter"];`,
+        `"input:in" -> "work" [label="in (2)"];`,
+        `"router" -> "strings" [label="default\ngood (1)"];`,
+    } {
+        if (!strings.Contains(dot, line)) {
+            t.Errorf("DOT doesn't contain %s:\n%s", line, dot)
+        }
+    }
+}
+
+func TestGraphDebugHandler(t *testing.T) {
+    h := serveTestGraph(t, mpserver.WithJobCounts()).DebugHandler()
+
+    rec := httptest.NewRecorder()
+    h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+    if (rec.Header().Get("Content-Type") != "application/json") {
+        t.Errorf("Content type is %q.", rec.Header().Get("Content-Type"))
+    }
+    var topology mpserver.Topology
+    if err := json.Unmarshal(rec.Body.Bytes(), &topology); err != nil {
+        t.Fatal(err)
+    }
+    if (len(topology.Nodes) != 4 || len(topology.Edges) != 4) {
+        t.Errorf("Served topology is %+v.", topology)
+    }
+
+    rec = httptest.NewRecorder()
+    h.ServeHTTP(rec, httptest.NewRequest("GET", "/?format=dot", nil))
+    if (!strings.HasPrefix(rec.Body.String(), "digraph pipeline {")) {
+        t.Errorf("Served DOT is %q.", rec.Body.String())
+    }
+}
@@ -0,0 +1,162 @@
+package mpserver_test
+
+import (
+    "errors"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "time"
+    "mpserver"
+    "mpserver/mpservertest"
+)
+
+// isError is a condition that holds for jobs with an error in 
+// the result field.
+func isError(job mpserver.Job) bool {
+    _, ok := job.GetResult().(error)
+    return ok
+}
+
+// failOn returns a component that sets an error result for 
+// requests of the provided path and "ok" for all other requests.
+func failOn(path string) mpserver.Component {
+    return mpserver.MakeComponent(func (job mpserver.Job) {
+        if (job.GetRequest().URL.Path == path) {
+            job.SetResult(errors.New("Failed."))
+        } else {
+            job.SetResult("ok")
+        }
+    })
+}
+
+// newTestGraph returns a graph that writes "ok" for all requests
+// except those of the /fail path, which get an error response.
+func newTestGraph(opts ...mpserver.GraphOption) *mpserver.Graph {
+    return mpserver.NewGraph(opts...).Input("in").
+        Component("work", failOn("/fail"), "in", "toRouter").
+        Router("router", "toRouter", "good", 
+               []string{"bad"}, []mpserver.Condition{isError}).
+        Writer("strings", mpserver.StringWriter, "good").
+        Writer("errors", mpserver.ErrorWriter, "bad")
+}
+
+func TestGraphBuild(t *testing.T) {
+    p, err := newTestGraph().Build()
+    if (err != nil) {
+        t.Fatal(err)
+    }
+    h, err := p.Handler("in")
+    if (err != nil) {
+        t.Fatal(err)
+    }
+    if _, err := p.Handler("out"); err == nil {
+        t.Error("Handler for an unknown edge was created.")
+    }
+    rec := httptest.NewRecorder()
+    h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+    if (rec.Code != http.StatusOK || rec.Body.String() != "ok") {
+        t.Errorf("Response is %d %q.", rec.Code, rec.Body.String())
+    }
+    rec = httptest.NewRecorder()
+    h.ServeHTTP(rec, httptest.NewRequest("GET", "/fail", nil))
+    if (rec.Code != http.StatusInternalServerError) {
+        t.Errorf("Response code is %d, want 500.", rec.Code)
+    }
+    p.Close()
+    p.Wait()
+}
+
+func TestGraphBuildWriter(t *testing.T) {
+    w, err := newTestGraph().BuildWriter()
+    if (err != nil) {
+        t.Fatal(err)
+    }
+    in := mpserver.GetChan()
+    done := make(chan bool)
+    go func () {
+        w(in)
+        close(done)
+    }()
+    rec := httptest.NewRecorder()
+    mpserver.Handler(in).ServeHTTP(rec, 
+        httptest.NewRequest("GET", "/", nil))
+    if (rec.Body.String() != "ok") {
+        t.Errorf("Response is %q, want ok.", rec.Body.String())
+    }
+    close(in)
+    <- done
+}
+
+func TestGraphValidate(t *testing.T) {
+    if err := newTestGraph().Validate(); err != nil {
+        t.Fatal(err)
+    }
+
+    g := mpserver.NewGraph().Input("in").
+        Component("a", mpserver.ConstantComponent(1), "in", "x").
+        Component("a", mpserver.ConstantComponent(1), "x", "y").
+        Router("r", "y", "z", []string{"q"}, nil).
+        Writer("w", mpserver.StringWriter, "z")
+    err := g.Validate()
+    if (err == nil) {
+        t.Fatal("Invalid graph passed validation.")
+    }
+    for _, problem := range []string{"Duplicate", 
+            "1 output edges and 0 conditions", `"q" has 0 consumers`} {
+        if (!strings.Contains(err.Error(), problem)) {
+            t.Errorf("Error %q doesn't report %q.", err, problem)
+        }
+    }
+    if _, err := g.Build(); err == nil {
+        t.Error("Invalid graph was built.")
+    }
+
+    cycle := mpserver.NewGraph().Input("in").
+        Writer("w", mpserver.StringWriter, "in").
+        Component("a", mpserver.ConstantComponent(1), "x", "y").
+        Component("b", mpserver.ConstantComponent(1), "y", "x")
+    if err := cycle.Validate(); err == nil || 
+            !strings.Contains(err.Error(), "cycle") {
+        t.Errorf("Cycle wasn't reported: %v.", err)
+    }
+}
+
+func TestGraphClock(t *testing.T) {
+    clock := mpservertest.NewFakeClock(time.Now())
+    release := make(chan bool)
+    worker := mpserver.MakeComponent(func (job mpserver.Job) {
+        <- release
+        job.SetResult("ok")
+    })
+    g := mpserver.NewGraph(mpserver.WithGraphClock(clock)).Input("in").
+        DynamicLoadBalancer("balancer", worker, 2, time.Minute,
+                            time.Hour, "in", "out").
+        Writer("w", mpserver.StringWriter, "out")
+    p, err := g.Build()
+    if (err != nil) {
+        t.Fatal(err)
+    }
+    workers := func () int64 {
+        return p.Topology().Nodes[0].Workers
+    }
+
+    in := p.Input("in")
+    for i := 0; i < 2; i++ {
+        go func () {
+            in <- mpservertest.NewGetJob()
+        }()
+    }
+    // The second job starts a worker once the add timeout passes
+    // on the graph clock.
+    eventually(t, "Worker wasn't added.", func () bool {
+        if (workers() == 2) {
+            return true
+        }
+        clock.Advance(time.Minute)
+        return false
+    })
+    close(release)
+    p.Close()
+    p.Wait()
+}
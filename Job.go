@@ -1,7 +1,13 @@
 package mpserver
 
 import (
+    "context"
+    "errors"
     "net/http"
+    "strconv"
+    "strings"
+    "sync"
+    "sync/atomic"
     "golang.org/x/net/websocket"
 )
 
@@ -13,6 +19,20 @@ type Job interface {
     // the client 
     GetRequest() *http.Request
 
+    // RequestID returns the id of the request. It is taken from
+    // the X-Request-Id header of the request if it is present,
+    // otherwise a new id is generated.
+    RequestID() string
+
+    // Logger returns the logger that should be used for messages
+    // about this job.
+    Logger() Logger
+
+    // Context returns the context of the client request. The 
+    // context is cancelled when the client goes away, in which
+    // case components should skip any further work on the job.
+    Context() context.Context
+
     // GetResult returns the current value in the result field.
     // The value is initially set to nil
     GetResult() interface{}
@@ -32,28 +52,143 @@ type Job interface {
     SetHeader(key string, value string)
 
     // Private methods
+    getAttr(key interface{}) (interface{}, bool)
+    setAttr(key interface{}, value interface{})
+    deleteAttr(key interface{})
+    getWebSocket() *websocket.Conn
+    getDone() <-chan struct{}
     getResponseWriter() http.ResponseWriter
     getResponseCode() int
     writeHeader()
     write([]byte)
     close()
+    abort(err error)
 }
 
 type jobStruct struct {
     request *http.Request
+    requestID string
     result interface{}
 
     responseCode int
     responseWriter http.ResponseWriter
+    // Headers of the response are set here and copied to the 
+    // response writer when the response is written, because the
+    // handler might be using it once the job was abandoned.
+    header http.Header
     webSocket *websocket.Conn
-    done chan<- bool
+    logger Logger
+
+    // The state of the job and whether anything was written to
+    // the response writer are guarded by the stateLock. The done
+    // channel is closed when the job is completed.
+    stateLock sync.Mutex
+    state jobState
+    wroteHeader bool
+    done chan struct{}
+
+    attrsLock sync.Mutex
+    attrs map[interface{}]interface{}
+}
+
+// jobState represents the completion state of a job.
+type jobState int
+
+const (
+    jobPending jobState = iota // The job is in the pipeline.
+    jobCompleted               // The response has been written.
+    jobAbandoned               // The handler gave up on the job.
+)
+
+// errJobFinished is returned when a response is written for a 
+// job that has already been completed or abandoned.
+var errJobFinished = errors.New(
+    "Response written for a job that was already finished.")
+
+// RequestIDHeader is the header that carries the request id.
+const RequestIDHeader = "X-Request-Id"
+
+// maxRequestIDLength is the maximum length of a request id that
+// is accepted from the client.
+const maxRequestIDLength = 128
+
+// requestCounter is used to generate request ids, when the 
+// random generator fails.
+var requestCounter uint64
+
+// requestIDFor returns the request id provided by the client in
+// the X-Request-Id header if it is valid, or a new random id.
+func requestIDFor(r *http.Request) string {
+    id := r.Header.Get(RequestIDHeader)
+    if (id != "" && len(id) <= maxRequestIDLength && 
+        strings.IndexFunc(id, func (c rune) bool {
+            return c < '!' || c > '~'
+        }) < 0) {
+        return id
+    }
+    id, err := GenerateRandomString(12)
+    if (err != nil) {
+        return strconv.FormatUint(
+            atomic.AddUint64(&requestCounter, 1), 10)
+    }
+    return id
 }
 
-func (job jobStruct) GetRequest() *http.Request {
+// newJob returns a job for the provided request, that writes 
+// its response using the provided writer. Its done channel is
+// closed when the response has been written. The request id of
+// the job is echoed in the response header.
+func newJob(w http.ResponseWriter, r *http.Request) *jobStruct {
+    id := requestIDFor(r)
+    w.Header().Set(RequestIDHeader, id)
+    return &jobStruct{
+        request: r,
+        requestID: id,
+        responseCode: UndefinedRespCode,
+        responseWriter: w,
+        header: w.Header().Clone(),
+        done: make(chan struct{}),
+    }
+}
+
+func (job *jobStruct) GetRequest() *http.Request {
     return job.request
 }
 
-func (job jobStruct) GetResult() interface{} {
+// NewJob returns a job for the provided request, that writes its
+// response using the provided writer. It allows running 
+// Components and Writers outside of handlers, for example in 
+// tests. Use Done to find out when the job is completed.
+func NewJob(w http.ResponseWriter, r *http.Request) Job {
+    return newJob(w, r)
+}
+
+// Done returns a channel that is closed when the provided job is
+// completed, that is when its response has been written.
+func Done(job Job) <-chan struct{} {
+    return job.getDone()
+}
+
+func (job *jobStruct) getDone() <-chan struct{} {
+    return job.done
+}
+
+func (job *jobStruct) RequestID() string {
+    return job.requestID
+}
+
+func (job *jobStruct) Logger() Logger {
+    if (job.logger == nil) {
+        return DefaultLogger()
+    }
+    return job.logger
+}
+
+func (job *jobStruct) Context() context.Context {
+    return job.request.Context()
+}
+
+func (job *jobStruct) GetResult() interface{} {
     return job.result
 }
 
@@ -71,26 +206,184 @@ func (job *jobStruct) SetResponseCodeIfUndef(responseCode int) {
     }
 }
 
-func (job jobStruct) SetHeader(key, value string) {
-    job.responseWriter.Header().Set(key, value)
+func (job *jobStruct) SetHeader(key, value string) {
+    job.getResponseWriter().Header().Set(key, value)
+}
+
+func (job *jobStruct) getAttr(key interface{}) (interface{}, bool) {
+    job.attrsLock.Lock()
+    defer job.attrsLock.Unlock()
+    value, ok := job.attrs[key]
+    return value, ok
 }
 
-func (job jobStruct) getResponseWriter() http.ResponseWriter {
-    return job.responseWriter
+func (job *jobStruct) setAttr(key, value interface{}) {
+    job.attrsLock.Lock()
+    defer job.attrsLock.Unlock()
+    if (job.attrs == nil) {
+        job.attrs = make(map[interface{}]interface{})
+    }
+    job.attrs[key] = value
 }
 
-func (job jobStruct) getResponseCode() int {
+func (job *jobStruct) deleteAttr(key interface{}) {
+    job.attrsLock.Lock()
+    defer job.attrsLock.Unlock()
+    delete(job.attrs, key)
+}
+
+func (job *jobStruct) getWebSocket() *websocket.Conn {
+    return job.webSocket
+}
+
+func (job *jobStruct) getResponseWriter() http.ResponseWriter {
+    return jobResponseWriter{job}
+}
+
+func (job *jobStruct) getResponseCode() int {
     return job.responseCode
 }
 
-func (job jobStruct) writeHeader() {
-    job.responseWriter.WriteHeader(job.responseCode);
+func (job *jobStruct) writeHeader() {
+    job.getResponseWriter().WriteHeader(job.responseCode);
+}
+
+func (job *jobStruct) write(body []byte) {
+    job.getResponseWriter().Write(body)
+}
+
+// close marks the job as completed and signals this to the 
+// handler. The done channel is also closed for a job that was 
+// abandoned, as it has left the pipeline. Completing a job more 
+// than once is harmless, but it indicates a bug in a Writer, so 
+// it is reported.
+func (job *jobStruct) close() {
+    job.stateLock.Lock()
+    defer job.stateLock.Unlock()
+    switch job.state {
+        case jobPending: {
+            job.flushHeader()
+            job.state = jobCompleted
+            close(job.done)
+        }
+        case jobCompleted: {
+            job.Logger().Error("job completed more than once", 
+                "request_id", job.requestID, 
+                "method", job.request.Method, 
+                "url", job.request.URL.String())
+        }
+        case jobAbandoned: {
+            // The handler has already responded, but the job has
+            // left the pipeline now.
+            job.state = jobCompleted
+            close(job.done)
+        }
+    }
+}
+
+// abandon marks a pending job as abandoned, so that nothing is
+// written for it anymore. It returns true if the job was 
+// pending and a boolean indicating whether a part of the 
+// response has already been written.
+func (job *jobStruct) abandon() (bool, bool) {
+    job.stateLock.Lock()
+    defer job.stateLock.Unlock()
+    if (job.state != jobPending) {
+        return false, job.wroteHeader
+    }
+    job.state = jobAbandoned
+    return true, job.wroteHeader
+}
+
+// abort answers the client with the error and the 500 response
+// code, unless the response was already started, and completes 
+// the job. Unlike writeError it doesn't touch the result, the 
+// response code or the headers of the job, so it can be called 
+// while a component still holds the job. Anything written for 
+// the job afterwards is dropped.
+func (job *jobStruct) abort(err error) {
+    job.stateLock.Lock()
+    defer job.stateLock.Unlock()
+    switch job.state {
+        case jobPending: {
+            if (!job.wroteHeader) {
+                job.wroteHeader = true
+                http.Error(job.responseWriter, err.Error(), 
+                    http.StatusInternalServerError)
+            }
+        }
+        case jobCompleted: return
+    }
+    job.state = jobCompleted
+    close(job.done)
+}
+
+// flushHeader copies the headers of the job to the response 
+// writer, unless the response has already been started. It must
+// be called with the stateLock held and the job pending.
+func (job *jobStruct) flushHeader() {
+    if (job.wroteHeader) {
+        return
+    }
+    job.wroteHeader = true
+    header := job.responseWriter.Header()
+    for key := range header {
+        if _, ok := job.header[key]; !ok {
+            delete(header, key)
+        }
+    }
+    for key, values := range job.header {
+        header[key] = values
+    }
 }
 
-func (job jobStruct) write(body []byte) {
-    job.responseWriter.Write(body)
+// jobResponseWriter is the http.ResponseWriter of a job. It 
+// drops everything that is written after the job was completed
+// or abandoned, because the handler might have returned by then.
+// Its headers are kept apart from the headers of the underlying
+// writer until the response is started.
+type jobResponseWriter struct {
+    job *jobStruct
 }
 
-func (job jobStruct) close() {
-    job.done <- true;
-}
\ No newline at end of file
+func (w jobResponseWriter) Header() http.Header {
+    return w.job.header
+}
+
+func (w jobResponseWriter) WriteHeader(responseCode int) {
+    w.job.stateLock.Lock()
+    defer w.job.stateLock.Unlock()
+    if (w.job.state == jobPending && !w.job.wroteHeader) {
+        w.job.flushHeader()
+        w.job.responseWriter.WriteHeader(responseCode)
+    }
+}
+
+func (w jobResponseWriter) Write(body []byte) (int, error) {
+    w.job.stateLock.Lock()
+    defer w.job.stateLock.Unlock()
+    if (w.job.state != jobPending) {
+        return 0, errJobFinished
+    }
+    w.job.flushHeader()
+    return w.job.responseWriter.Write(body)
+}
+
+// isCancelled reports whether the client that made the request
+// of the provided job has gone away, so that any further work 
+// on the job would be wasted.
+func isCancelled(job Job) bool {
+    return job.Context().Err() != nil
+}
+
+// skipCancelled stores the cancellation error in the result 
+// field of the provided job if its client has gone away. It 
+// returns true if that is the case, in which case the job 
+// should be passed on without any further processing.
+func skipCancelled(job Job) bool {
+    if err := job.Context().Err(); err != nil {
+        job.SetResult(err)
+        return true
+    }
+    return false
+}
@@ -0,0 +1,176 @@
+package mpserver_test
+
+import (
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "sync"
+    "testing"
+    "time"
+    "mpserver"
+    "mpserver/mpservertest"
+)
+
+// logEntry is a message logged to a logRecorder with its fields.
+type logEntry struct {
+    level string
+    msg string
+    fields map[string]string
+}
+
+// logRecorder is a Logger that records the logged messages.
+type logRecorder struct {
+    lock sync.Mutex
+    entries []logEntry
+}
+
+func (l *logRecorder) log(level, msg string, args []any) {
+    l.lock.Lock()
+    defer l.lock.Unlock()
+    fields := make(map[string]string)
+    for i := 0; i+1 < len(args); i += 2 {
+        fields[fmt.Sprint(args[i])] = fmt.Sprint(args[i+1])
+    }
+    l.entries = append(l.entries, logEntry{level, msg, fields})
+}
+
+func (l *logRecorder) Debug(msg string, args ...any) {
+    l.log("DEBUG", msg, args)
+}
+
+func (l *logRecorder) Info(msg string, args ...any) {
+    l.log("INFO", msg, args)
+}
+
+func (l *logRecorder) Warn(msg string, args ...any) {
+    l.log("WARN", msg, args)
+}
+
+func (l *logRecorder) Error(msg string, args ...any) {
+    l.log("ERROR", msg, args)
+}
+
+// find returns the entries with the provided message.
+func (l *logRecorder) find(msg string) []logEntry {
+    l.lock.Lock()
+    defer l.lock.Unlock()
+    var res []logEntry
+    for _, entry := range l.entries {
+        if (entry.msg == msg) {
+            res = append(res, entry)
+        }
+    }
+    return res
+}
+
+func TestJobLateWriteAfterDeadline(t *testing.T) {
+    logger := &logRecorder{}
+    out := mpserver.GetChan()
+    h := mpserver.Handler(out, mpserver.WithName("slow"),
+                          mpserver.WithLogger(logger),
+                          mpserver.WithDeadline(10*time.Millisecond))
+    rec := httptest.NewRecorder()
+    done := make(chan struct{})
+    go func () {
+        defer close(done)
+        h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+    }()
+    job := <- out
+    <- done
+    if (rec.Code != http.StatusInternalServerError) {
+        t.Errorf("Response code is %d, want 500.", rec.Code)
+    }
+
+    // The pipeline writes the job after the handler has responded.
+    job.SetResult("late")
+    err := mpservertest.RunWriter(mpserver.StringWriter, job)
+    if (err != nil) {
+        t.Fatal(err)
+    }
+    if (rec.Body.String() != "Job was lost.\n") {
+        t.Errorf("Body is %q, the late write wasn't dropped.",
+                 rec.Body.String())
+    }
+
+    lost := logger.find("pipeline lost the job")
+    if (len(lost) != 1) {
+        t.Fatalf("Logged %d lost jobs, want 1.", len(lost))
+    }
+    if (lost[0].fields["pipeline"] != "slow") {
+        t.Errorf("Lost job logged for pipeline %q, want slow.",
+                 lost[0].fields["pipeline"])
+    }
+    if (len(logger.find("job completed more than once")) != 0) {
+        t.Error("Late write of an abandoned job was reported.")
+    }
+}
+
+func TestJobCompletedTwice(t *testing.T) {
+    logger := &logRecorder{}
+    mpserver.SetDefaultLogger(logger)
+    defer mpserver.SetDefaultLogger(nil)
+
+    job, rec := mpservertest.NewRequestJob("GET", "/", nil)
+    job.SetResult("once")
+    for i := 0; i < 2; i++ {
+        err := mpservertest.RunWriter(mpserver.StringWriter, job)
+        if (err != nil) {
+            t.Fatal(err)
+        }
+    }
+    expect(t, rec, http.StatusOK, "once")
+    if (len(logger.find("job completed more than once")) != 1) {
+        t.Error("Second completion wasn't reported once.")
+    }
+}
+
+func TestRequestIDEchoed(t *testing.T) {
+    r := httptest.NewRequest("GET", "/", nil)
+    r.Header.Set(mpserver.RequestIDHeader, "abc-123")
+    job, rec := mpservertest.NewJob(r)
+    if (job.RequestID() != "abc-123") {
+        t.Errorf("Request id is %q, want abc-123.", job.RequestID())
+    }
+    if (rec.Header().Get(mpserver.RequestIDHeader) != "abc-123") {
+        t.Errorf("Echoed request id is %q, want abc-123.",
+                 rec.Header().Get(mpserver.RequestIDHeader))
+    }
+
+    // Ids with spaces are replaced.
+    r = httptest.NewRequest("GET", "/", nil)
+    r.Header.Set(mpserver.RequestIDHeader, "a b")
+    job, _ = mpservertest.NewJob(r)
+    if (job.RequestID() == "a b" || job.RequestID() == "") {
+        t.Errorf("Invalid request id %q was accepted.", job.RequestID())
+    }
+}
+
+func TestRequestIDForwarded(t *testing.T) {
+    upstreamID := make(chan string, 1)
+    upstream := httptest.NewServer(http.HandlerFunc(
+        func (w http.ResponseWriter, r *http.Request) {
+            upstreamID <- r.Header.Get(mpserver.RequestIDHeader)
+        }))
+    defer upstream.Close()
+
+    job, rec := mpservertest.NewRequestJob("GET", "/", nil)
+    id := rec.Header().Get(mpserver.RequestIDHeader)
+    if (id == "" || id != job.RequestID()) {
+        t.Fatalf("Generated request id %q, echoed %q.",
+                 job.RequestID(), id)
+    }
+    req, err := http.NewRequest("GET", upstream.URL, nil)
+    if (err != nil) {
+        t.Fatal(err)
+    }
+    job.SetResult(req)
+    res := run(t, mpserver.NetworkComponent(upstream.Client()), job)
+    if resp, ok := res[0].GetResult().(*http.Response); ok {
+        resp.Body.Close()
+    } else {
+        t.Fatalf("Result is %v, want a response.", res[0].GetResult())
+    }
+    if got := <- upstreamID; got != id {
+        t.Errorf("Upstream got request id %q, want %q.", got, id)
+    }
+}
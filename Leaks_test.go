@@ -0,0 +1,72 @@
+package mpserver_test
+
+import (
+    "testing"
+    "time"
+    "mpserver"
+    "mpserver/mpservertest"
+)
+
+// routed is a component that splits its input jobs by a Router
+// and merges them back by a Collector.
+func routed(in <-chan mpserver.Job, out chan<- mpserver.Job) {
+    def := mpserver.GetChan()
+    errs := mpserver.GetChan()
+    go mpserver.Router(in, def, 
+        mpserver.ToOutChans([]chan mpserver.Job{errs}), 
+        []mpserver.Condition{isError})
+    mpserver.Collector(
+        mpserver.ToInChans([]chan mpserver.Job{def, errs}), out)
+}
+
+func TestComponentsShutdown(t *testing.T) {
+    n := 0
+    // Panics on every second job.
+    panicky := func (in <-chan mpserver.Job, out chan<- mpserver.Job) {
+        for job := range in {
+            n++
+            if (n % 2 == 0) {
+                panic("Panic.")
+            }
+            out <- job
+        }
+        close(out)
+    }
+    identity := mpservertest.Identity
+    components := map[string]mpserver.Component{
+        "LinkComponents": mpserver.LinkComponents(
+            identity, identity, mpserver.ConstantComponent(1)),
+        "ErrorPasser": mpserver.ErrorPasser(identity),
+        "PannicHandler": mpserver.PannicHandler(panicky),
+        "StaticLoadBalancer": mpserver.StaticLoadBalancer(identity, 4),
+        "DynamicLoadBalancer": mpserver.DynamicLoadBalancer(
+            identity, 4, time.Millisecond, time.Millisecond),
+        "CacheComponent": mpserver.CacheComponent(
+            mpserver.NewMemStorage(), identity, time.Minute),
+        "Router": routed,
+    }
+    for name, c := range components {
+        if err := mpservertest.CheckComponentShutdown(
+                c, newJobs(6)...); err != nil {
+            t.Errorf("%s: %v", name, err)
+        }
+    }
+}
+
+func TestWritersShutdown(t *testing.T) {
+    writers := map[string]mpserver.Writer{
+        "StaticLoadBalancerWriter": mpserver.StaticLoadBalancerWriter(
+            mpserver.StringWriter, 3),
+        "DynamicLoadBalancerWriter": mpserver.DynamicLoadBalancerWriter(
+            mpserver.StringWriter, 3, time.Millisecond, time.Millisecond),
+    }
+    for name, w := range writers {
+        jobs := newJobs(6)
+        for _, job := range jobs {
+            job.SetResult("x")
+        }
+        if err := mpservertest.CheckWriterShutdown(w, jobs...); err != nil {
+            t.Errorf("%s: %v", name, err)
+        }
+    }
+}
@@ -1,7 +1,13 @@
 package mpserver
 
-import "net/http"
-import "log"
+import (
+    "errors"
+    "net"
+    "net/http"
+    "os"
+    "strconv"
+    "time"
+)
 
 const UndefinedRespCode int = -1;
 
@@ -16,37 +22,159 @@ func GetChan() chan Job {
 
 // ----------------------- HTPP Handlers ------------------------
 
+// handlerConfig holds the settings of a handler created by the
+// HandlerFunction function.
+type handlerConfig struct {
+    name string
+    logger Logger
+    admission *Admission
+    deadline time.Duration
+    maxBodyBytes int64
+}
+
+// HandlerOption configures a handler created by HandlerFunction,
+// Handler or Listen.
+type HandlerOption func (config *handlerConfig)
+
+// newHandlerConfig applies the provided options to the default 
+// handler settings.
+func newHandlerConfig(opts []HandlerOption) *handlerConfig {
+    config := &handlerConfig{}
+    for _, opt := range opts {
+        opt(config)
+    }
+    return config
+}
+
 // HandlerFunction takes an output channel and returns a function
 // that can be used with the http.HandlerFunc to generate a
 // http.Handler. This handler will for each incoming request 
-// create a Job object and send it to the output channel.
-func HandlerFunction(out chan<- Job) (
+// create a Job object and send it to the output channel. If the
+// client goes away before the job is accepted by the pipeline, 
+// the job is dropped.
+func HandlerFunction(out chan<- Job, opts ...HandlerOption) (
     func (http.ResponseWriter, *http.Request)) {
+    config := newHandlerConfig(opts)
     return func (w http.ResponseWriter, r *http.Request) {
-        done := make(chan bool)
-        w.Header().Set("Server", "mpserver")
-        out <- &jobStruct{
-            r, nil, UndefinedRespCode, w, nil, done}
-        <- done
+        config.serve(w, r, func (job *jobStruct) bool {
+            return config.send(job, out)
+        })
     }  
 }
 
+// serve creates a job for the provided request, sends it to a
+// pipeline using the provided send function and waits for it to
+// be completed.
+func (config *handlerConfig) serve(w http.ResponseWriter, 
+        r *http.Request, send func (job *jobStruct) bool) {
+    w.Header().Set("Server", "mpserver")
+    if (!limitBody(w, r, config.maxBodyBytes)) {
+        return
+    }
+    job := newJob(w, r)
+    job.logger = config.logger
+    if (!send(job)) {
+        return
+    }
+    if (config.admission != nil) {
+        defer config.admission.release()
+    }
+    config.wait(job)
+}
+
+// send sends the job to the output channel, enforcing the 
+// admission policy if there is one. It returns false if the job
+// wasn't sent, because it was rejected or the client went away.
+func (config *handlerConfig) send(job *jobStruct, 
+                                  out chan<- Job) bool {
+    if (config.admission != nil) {
+        return config.admission.admit(job, out)
+    }
+    select {
+        case out <- job: return true
+        case <- job.Context().Done(): return false
+    }
+}
+
+// wait waits for the provided job to be completed. If the client
+// goes away first, the job is abandoned silently. If the deadline
+// is set and the job isn't completed before it, the job is 
+// considered lost. The loss is logged and the client gets a 
+// response with the 500 response code.
+func (config *handlerConfig) wait(job *jobStruct) {
+    var timeout <-chan time.Time
+    if (config.deadline > 0) {
+        timer := time.NewTimer(config.deadline)
+        defer timer.Stop()
+        timeout = timer.C
+    }
+    select {
+        case <- job.done: {}
+        case <- job.Context().Done(): {
+            // Nobody waits for the response anymore.
+            job.abandon()
+        }
+        case <- timeout: {
+            abandoned, wroteHeader := job.abandon()
+            if (!abandoned) {
+                // The job was completed in the meantime.
+                return
+            }
+            name := config.name
+            if (name == "") {
+                name = "(unnamed)"
+            }
+            job.Logger().Error("pipeline lost the job",
+                "request_id", job.requestID, "pipeline", name,
+                "method", job.request.Method, 
+                "url", job.request.URL.String(),
+                "deadline", config.deadline, 
+                "status", http.StatusInternalServerError)
+            if (!wroteHeader) {
+                http.Error(job.responseWriter, "Job was lost.", 
+                    http.StatusInternalServerError)
+            }
+        }
+    }
+}
+
+// WithName returns a HandlerOption that sets the name of the 
+// pipeline that the handler sends jobs to. The name is used in
+// diagnostics. Listen uses the url as the default name.
+func WithName(name string) HandlerOption {
+    return func (config *handlerConfig) {
+        config.name = name
+    }
+}
+
+// WithDeadline returns a HandlerOption that sets a deadline for 
+// the completion of jobs. If a job isn't written before the 
+// deadline, the pipeline is assumed to have lost it and the 
+// handler responds with the 500 response code.
+func WithDeadline(deadline time.Duration) HandlerOption {
+    return func (config *handlerConfig) {
+        config.deadline = deadline
+    }
+}
+
 // Handler returns an http.Handler object that for each incoming 
 // request creates a Job object and sends it to the output 
 // channel.
-func Handler(out chan<- Job) http.Handler {
-    return http.HandlerFunc(HandlerFunction(out))
+func Handler(out chan<- Job, opts ...HandlerOption) http.Handler {
+    return http.HandlerFunc(HandlerFunction(out, opts...))
 }
 
 // Listen registers a handler on the provided ServeMux for the 
 // provided url, that will for each incoming request create 
 // a Job object and send it to the output channel. If the 
 // provided ServeMux is nil DefaultServeMux is used.
-func Listen(url string, out chan<- Job, mux *http.ServeMux) {
+func Listen(url string, out chan<- Job, mux *http.ServeMux, 
+            opts ...HandlerOption) {
+    opts = append([]HandlerOption{WithName(url)}, opts...)
     if (mux != nil) {
-        mux.HandleFunc(url, HandlerFunction(out))
+        mux.HandleFunc(url, HandlerFunction(out, opts...))
     } else {
-        DefaultServeMux.HandleFunc(url, HandlerFunction(out))
+        DefaultServeMux.HandleFunc(url, HandlerFunction(out, opts...))
     }
 }
 
@@ -54,9 +182,49 @@ func Listen(url string, out chan<- Job, mux *http.ServeMux) {
 // http package with the provided handler if the handler is not 
 // nil. It otherwise uses the DefaultServeMux.
 func ListenAndServe(addr string, handler http.Handler) error {
-    log.Println("Listening at", addr)
+    DefaultLogger().Info("listening", "addr", addr)
     if (handler != nil) {
         return http.ListenAndServe(addr, handler)
     }
     return http.ListenAndServe(addr, DefaultServeMux)
 }
+
+// listenFdsStart is the first file descriptor passed by socket 
+// activation.
+const listenFdsStart = 3
+
+// InheritedListeners returns the listeners passed to the process
+// by socket activation, as done by systemd. The listening sockets
+// are file descriptors starting from 3, their number is in the 
+// LISTEN_FDS environment variable and LISTEN_PID has to match 
+// the pid of the process. The variables are unset, so that they
+// are not passed to child processes.
+func InheritedListeners() ([]net.Listener, error) {
+    pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+    if (err != nil || pid != os.Getpid()) {
+        return nil, errors.New(
+            "No listeners were passed to this process.")
+    }
+    n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+    if (err != nil || n <= 0) {
+        return nil, errors.New("Invalid LISTEN_FDS variable.")
+    }
+    os.Unsetenv("LISTEN_PID")
+    os.Unsetenv("LISTEN_FDS")
+    os.Unsetenv("LISTEN_FDNAMES")
+
+    listeners := make([]net.Listener, 0, n)
+    for fd := listenFdsStart; fd < listenFdsStart+n; fd++ {
+        file := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+        l, err := net.FileListener(file)
+        file.Close()
+        if (err != nil) {
+            for _, opened := range listeners {
+                opened.Close()
+            }
+            return nil, err
+        }
+        listeners = append(listeners, l)
+    }
+    return listeners, nil
+}
@@ -0,0 +1,152 @@
+package mpserver_test
+
+import (
+    "context"
+    "io"
+    "net"
+    "net/http"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "strconv"
+    "testing"
+    "mpserver"
+)
+
+// get sends a GET request using the client and returns the body
+// of the response.
+func get(t *testing.T, client *http.Client, url string) string {
+    t.Helper()
+    resp, err := client.Get(url)
+    if (err != nil) {
+        t.Fatal(err)
+    }
+    defer resp.Body.Close()
+    body, err := io.ReadAll(resp.Body)
+    if (err != nil) {
+        t.Fatal(err)
+    }
+    return string(body)
+}
+
+// unixClient returns a client that sends all requests to the
+// Unix domain socket with the provided path.
+func unixClient(path string) *http.Client {
+    return &http.Client{Transport: &http.Transport{
+        DialContext: func (ctx context.Context,
+                           network, addr string) (net.Conn, error) {
+            var d net.Dialer
+            return d.DialContext(ctx, "unix", path)
+        },
+    }}
+}
+
+func TestServerListeners(t *testing.T) {
+    s := mpserver.NewServer("")
+    s.Route("/", slowWriter("hello", 0))
+    path := filepath.Join(t.TempDir(), "mpserver.sock")
+    if err := s.ListenUnix(path); err != nil {
+        t.Fatal(err)
+    }
+    l, err := net.Listen("tcp", "127.0.0.1:0")
+    if (err != nil) {
+        t.Fatal(err)
+    }
+    s.AddListener(l)
+    res := make(chan error, 1)
+    go func () {
+        res <- s.Serve()
+    }()
+
+    unix := unixClient(path)
+    tcpURL := "http://" + l.Addr().String()
+    eventually(t, "Server didn't start serving.", func () bool {
+        resp, err := unix.Get("http://unix/")
+        if (err != nil) {
+            return false
+        }
+        resp.Body.Close()
+        return true
+    })
+    if body := get(t, unix, "http://unix/"); body != "hello" {
+        t.Errorf("Response over the socket is %q, want hello.", body)
+    }
+    if body := get(t, http.DefaultClient, tcpURL); body != "hello" {
+        t.Errorf("Response over TCP is %q, want hello.", body)
+    }
+
+    shutdown(t, s)
+    if err := <- res; err != http.ErrServerClosed {
+        t.Errorf("Serve returned %v, want ErrServerClosed.", err)
+    }
+    if _, err := net.Dial("tcp", l.Addr().String()); err == nil {
+        t.Error("TCP listener is open after Shutdown.")
+    }
+    if _, err := os.Stat(path); !os.IsNotExist(err) {
+        t.Error("Socket file wasn't removed on Shutdown.")
+    }
+}
+
+// inheritedEnv marks the process started by TestInheritedListeners.
+const inheritedEnv = "MPSERVER_TEST_INHERITED"
+
+func TestInheritedListeners(t *testing.T) {
+    if (os.Getenv(inheritedEnv) != "") {
+        serveInherited(t)
+        return
+    }
+    t.Setenv("LISTEN_PID", "1")
+    t.Setenv("LISTEN_FDS", "1")
+    if _, err := mpserver.InheritedListeners(); err == nil {
+        t.Error("Listeners of another process were accepted.")
+    }
+
+    l, err := net.Listen("tcp", "127.0.0.1:0")
+    if (err != nil) {
+        t.Fatal(err)
+    }
+    file, err := l.(*net.TCPListener).File()
+    l.Close()
+    if (err != nil) {
+        t.Fatal(err)
+    }
+    cmd := exec.Command(os.Args[0],
+                        "-test.run=^TestInheritedListeners$")
+    cmd.Env = append(os.Environ(), inheritedEnv + "=1",
+                     "LISTEN_FDS=1")
+    cmd.ExtraFiles = []*os.File{file}
+    if err := cmd.Start(); err != nil {
+        t.Fatal(err)
+    }
+    file.Close()
+
+    // The child serves one request and shuts down.
+    body := get(t, http.DefaultClient, "http://" + l.Addr().String())
+    if (body != "inherited") {
+        t.Errorf("Response is %q, want inherited.", body)
+    }
+    if err := cmd.Wait(); err != nil {
+        t.Errorf("Child process failed: %v", err)
+    }
+}
+
+// serveInherited serves one request on the inherited listener
+// and then shuts the server down.
+func serveInherited(t *testing.T) {
+    // Socket activation sets the pid after the fork.
+    os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+    s := mpserver.NewServer("")
+    served := make(chan bool)
+    s.Route("/", mpserver.MakeWriter(
+        func (job mpserver.Job) ([]byte, error) {
+            close(served)
+            return []byte("inherited"), nil
+        }))
+    if err := s.ListenInherited(); err != nil {
+        t.Fatal(err)
+    }
+    go s.Serve()
+    // Shutdown waits for the response to be written.
+    <- served
+    shutdown(t, s)
+}
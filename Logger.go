@@ -0,0 +1,51 @@
+package mpserver
+
+import (
+    "log/slog"
+    "sync"
+)
+
+// Logger is the interface that mpserver uses for logging. The
+// arguments after the message are alternating keys and values of
+// structured fields, such as "request_id", "component" and
+// "status". It is satisfied by *slog.Logger.
+type Logger interface {
+    Debug(msg string, args ...any)
+    Info(msg string, args ...any)
+    Warn(msg string, args ...any)
+    Error(msg string, args ...any)
+}
+
+var (
+    loggerLock sync.RWMutex
+    defaultLogger Logger
+)
+
+// SetDefaultLogger sets the logger used by handlers and 
+// components that don't have a logger configured. Setting it 
+// to nil restores the default, which is slog.Default().
+func SetDefaultLogger(logger Logger) {
+    loggerLock.Lock()
+    defer loggerLock.Unlock()
+    defaultLogger = logger
+}
+
+// DefaultLogger returns the logger used by handlers and 
+// components that don't have a logger configured.
+func DefaultLogger() Logger {
+    loggerLock.RLock()
+    defer loggerLock.RUnlock()
+    if (defaultLogger == nil) {
+        return slog.Default()
+    }
+    return defaultLogger
+}
+
+// WithLogger returns a HandlerOption that sets the logger of the 
+// jobs created by the handler. Components and Writers use this 
+// logger for messages about the job.
+func WithLogger(logger Logger) HandlerOption {
+    return func (config *handlerConfig) {
+        config.logger = logger
+    }
+}
@@ -0,0 +1,155 @@
+package mpserver
+
+import (
+    "net/http"
+    "path"
+    "strings"
+    "sync"
+)
+
+// moduleRoute is a route of a module.
+type moduleRoute struct {
+    path string
+    writer Writer
+    opts []HandlerOption
+}
+
+// Module is a reusable set of routes, whose pipelines can share 
+// state such as a Storage, together with background tasks that 
+// maintain that state. A module is mounted under a path prefix 
+// on a ServeMux or a Server. The prefix is stripped from the URL
+// path of requests before they are passed to the pipelines, so
+// components such as PathMaker see the same paths regardless of 
+// where the module is mounted.
+//
+// The writers of a module are started once per mount, so a 
+// module that is mounted more than once should not hold writers 
+// that keep state between jobs. Use a function that returns a new
+// Module instead.
+type Module struct {
+    name string
+    routes []moduleRoute
+    tasks []func (shutDown <-chan bool)
+}
+
+// NewModule returns an empty module with the provided name. The
+// name is used in diagnostics.
+func NewModule(name string) *Module {
+    return &Module{name: name}
+}
+
+// Route adds a route for the provided path relative to the mount
+// point of the module, that is served by the writer. The path is
+// taken as rooted, so "add" and "/add" are the same route.
+func (m *Module) Route(path string, writer Writer, 
+                       opts ...HandlerOption) *Module {
+    m.routes = append(m.routes, moduleRoute{path, writer, opts})
+    return m
+}
+
+// Background adds a task that is run in its own goroutine while 
+// the module is mounted. The shutDown channel is closed when the 
+// module is unmounted. The signature matches StorageCleaner, so 
+// that expired sessions of the module can be removed by:
+//
+//     m.Background(func (shutDown <-chan bool) {
+//         mpserver.StorageCleaner(storage, shutDown, time.Minute)
+//     })
+func (m *Module) Background(task func (shutDown <-chan bool)) *Module {
+    m.tasks = append(m.tasks, task)
+    return m
+}
+
+// mountPrefix returns the prefix that is stripped from the URL 
+// paths of requests to a module mounted under the provided 
+// prefix. It starts with a slash, unless it is empty, and doesn't
+// end with one.
+func mountPrefix(prefix string) string {
+    return strings.TrimSuffix(path.Join("/", prefix), "/")
+}
+
+// mountPath returns the path of the route under the prefix. The 
+// two are joined by a single slash, whether or not either of them
+// provides it, and a trailing slash of the route is kept, so that
+// it still registers a subtree on the ServeMux.
+func mountPath(prefix, route string) string {
+    joined := path.Join("/", prefix, route)
+    if (strings.HasSuffix(route, "/") && joined != "/") {
+        joined += "/"
+    }
+    return joined
+}
+
+// MountedModule is a module mounted on a ServeMux. 
+type MountedModule struct {
+    inputs []chan Job
+    writers sync.WaitGroup
+    shutDown chan bool
+    closeOnce sync.Once
+}
+
+// Mount registers the routes of the module under the prefix on 
+// the provided ServeMux, starts their writers and the background
+// tasks. If the ServeMux is nil, DefaultServeMux is used. 
+func (m *Module) Mount(prefix string, 
+                       mux *http.ServeMux) *MountedModule {
+    if (mux == nil) {
+        mux = DefaultServeMux
+    }
+    strip := mountPrefix(prefix)
+    mounted := &MountedModule{shutDown: make(chan bool)}
+    for _, route := range m.routes {
+        url := mountPath(prefix, route.path)
+        in := GetChan()
+        mounted.inputs = append(mounted.inputs, in)
+        mounted.writers.Add(1)
+        go func (writer Writer) {
+            defer mounted.writers.Done()
+            writer(in)
+        }(route.writer)
+
+        opts := append([]HandlerOption{WithName(m.name + ":" + url)},
+            route.opts...)
+        mux.Handle(url, http.StripPrefix(strip, Handler(in, opts...)))
+    }
+    for _, task := range m.tasks {
+        go task(mounted.shutDown)
+    }
+    return mounted
+}
+
+// Close closes the input channels of the pipelines of the module,
+// waits for their writers to terminate and stops the background
+// tasks. It must be called only after the http server stopped 
+// serving requests, as ServeMux doesn't allow removing handlers.
+func (mounted *MountedModule) Close() {
+    mounted.closeOnce.Do(func () {
+        for _, in := range mounted.inputs {
+            close(in)
+        }
+        mounted.writers.Wait()
+        close(mounted.shutDown)
+    })
+}
+
+// MountServer registers the routes of the module under the 
+// prefix on the server. The pipelines and background tasks of 
+// the module are shut down together with the server.
+func (m *Module) MountServer(prefix string, s *Server) {
+    strip := mountPrefix(prefix)
+    for _, route := range m.routes {
+        url := mountPath(prefix, route.path)
+        in := GetChan()
+        s.Start(route.writer, in)
+        opts := append([]HandlerOption{WithName(m.name + ":" + url)},
+            route.opts...)
+        s.listen(url, in, strip, opts)
+    }
+    if (len(m.tasks) > 0) {
+        shutDown := make(chan bool)
+        for _, task := range m.tasks {
+            go task(shutDown)
+        }
+        s.onShutdown(func () { close(shutDown) })
+    }
+}
@@ -0,0 +1,105 @@
+package mpserver_test
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+    "mpserver"
+)
+
+// pathWriter is a writer that responds with the URL path of the
+// request, as seen by the pipeline.
+var pathWriter = mpserver.MakeWriter(
+    func (job mpserver.Job) ([]byte, error) {
+        return []byte(job.GetRequest().URL.Path), nil
+    })
+
+// testModule returns a module with a route given without the
+// leading slash, a subtree and a background task that closes
+// stopped when it is stopped.
+func testModule(stopped chan bool) *mpserver.Module {
+    return mpserver.NewModule("test").
+        Route("add", pathWriter).
+        Route("/files/", pathWriter).
+        Background(func (shutDown <-chan bool) {
+            <- shutDown
+            close(stopped)
+        })
+}
+
+// serveModule serves a request for every path with the handler 
+// and checks that the response has the expected body, or that 
+// the path isn't found if the expected body is empty.
+func serveModule(t *testing.T, h http.Handler,
+                 want map[string]string) {
+    t.Helper()
+    for path, body := range want {
+        rec := httptest.NewRecorder()
+        h.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+        code := http.StatusOK
+        if (body == "") {
+            code = http.StatusNotFound
+        }
+        if (rec.Code != code) {
+            t.Errorf("%s: response code is %d, want %d.",
+                     path, rec.Code, code)
+        } else if (body != "" && rec.Body.String() != body) {
+            t.Errorf("%s: body is %q, want %q.",
+                     path, rec.Body.String(), body)
+        }
+    }
+}
+
+// A prefix with or without a trailing slash mounts the routes
+// under it with a single slash in between.
+func TestModuleMount(t *testing.T) {
+    for _, prefix := range []string{"/api", "/api/", "api"} {
+        stopped := make(chan bool)
+        mux := http.NewServeMux()
+        mounted := testModule(stopped).Mount(prefix, mux)
+        // The prefix is stripped from the paths the pipelines see.
+        serveModule(t, mux, map[string]string{
+            "/api/add": "/add",
+            "/api/files/a/b": "/files/a/b",
+            "/apiadd": "",
+            "/add": "",
+        })
+        mounted.Close()
+        select {
+            case <- stopped: {}
+            case <- time.After(time.Second): {
+                t.Errorf("%s: background task wasn't stopped.", prefix)
+            }
+        }
+    }
+}
+
+func TestModuleMountRoot(t *testing.T) {
+    mux := http.NewServeMux()
+    mounted := testModule(make(chan bool)).Mount("/", mux)
+    defer mounted.Close()
+    serveModule(t, mux, map[string]string{
+        "/add": "/add",
+        "/files/a": "/files/a",
+        "/api/add": "",
+    })
+}
+
+func TestModuleMountServer(t *testing.T) {
+    s := mpserver.NewServer("")
+    stopped := make(chan bool)
+    testModule(stopped).MountServer("/api/", s)
+    serveModule(t, s, map[string]string{
+        "/api/add": "/add",
+        "/api/files/a": "/files/a",
+        "/apiadd": "",
+    })
+    shutdown(t, s)
+    select {
+        case <- stopped: {}
+        case <- time.After(time.Second): {
+            t.Error("Background task wasn't stopped on Shutdown.")
+        }
+    }
+}
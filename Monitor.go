@@ -0,0 +1,292 @@
+package mpserver
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "sync"
+    "time"
+)
+
+// Monitor tracks the jobs that pass through the stages of a 
+// pipeline in order to find stages that got stuck. A stage is 
+// stuck if it holds a job for longer than the threshold of the 
+// monitor without emitting anything, while it isn't waiting for 
+// the next stage to read its output. That is the stage itself 
+// stopped making progress, not the stages after it.
+//
+// Stages are added by wrapping components using the Stage or 
+// LinkComponents methods. The wrapping costs two extra channel 
+// hops per stage, so the monitor is meant to be enabled when 
+// diagnosing a pipeline.
+type Monitor struct {
+    threshold time.Duration
+    clock Clock
+    lock sync.Mutex
+    stages []*stageStats
+    logger Logger
+}
+
+// stageStats holds the counters of a stage of a monitor.
+type stageStats struct {
+    name string
+    lock sync.Mutex
+    received uint64
+    emitted uint64
+    held map[Job]time.Time // Jobs in the stage by arrival time.
+    lastEmission time.Time // Or the creation of the stage.
+    // Instances of the stage whose output is blocked, by the time
+    // they started waiting.
+    blocked map[int]time.Time
+    instances int // Number of started instances.
+}
+
+// StageStats is a snapshot of the state of a monitored stage.
+type StageStats struct {
+    Name string `json:"name"`
+    Received uint64 `json:"received"`
+    Emitted uint64 `json:"emitted"`
+    InFlight int `json:"inFlight"`
+    // Time since the stage last emitted a job.
+    SinceEmission Duration `json:"sinceEmission"`
+    // Time the oldest job has been held by the stage.
+    OldestJob Duration `json:"oldestJob,omitempty"`
+    // Number of instances of the stage waiting for the next stage
+    // to read their output.
+    BlockedOutputs int `json:"blockedOutputs,omitempty"`
+    // Time the instance that has been blocked the longest has 
+    // been waiting for the next stage to read its output.
+    OutputBlocked Duration `json:"outputBlocked,omitempty"`
+    Stuck bool `json:"stuck"`
+}
+
+// MonitorReport is a snapshot of all stages of a monitor.
+type MonitorReport struct {
+    Stages []StageStats `json:"stages"`
+    // Names of the stuck stages.
+    Stuck []string `json:"stuck,omitempty"`
+    // Deadlocked is true if some stages hold jobs, but none of
+    // them emitted anything within the threshold.
+    Deadlocked bool `json:"deadlocked"`
+}
+
+// NewMonitor returns a monitor that considers a stage stuck when
+// it holds a job for longer than the threshold.
+func NewMonitor(threshold time.Duration) *Monitor {
+    return NewMonitorWithClock(threshold, SystemClock)
+}
+
+// NewMonitorWithClock returns a monitor that measures time by the
+// provided clock.
+func NewMonitorWithClock(threshold time.Duration, 
+                         clock Clock) *Monitor {
+    return &Monitor{threshold: threshold, clock: clock}
+}
+
+// SetLogger sets the logger that Watch reports stuck stages to.
+// By default the DefaultLogger is used.
+func (m *Monitor) SetLogger(logger Logger) {
+    m.lock.Lock()
+    defer m.lock.Unlock()
+    m.logger = logger
+}
+
+// getLogger returns the logger of the monitor or the default 
+// logger if it isn't set.
+func (m *Monitor) getLogger() Logger {
+    m.lock.Lock()
+    defer m.lock.Unlock()
+    if (m.logger == nil) {
+        return DefaultLogger()
+    }
+    return m.logger
+}
+
+// Stage returns a component that runs the worker and records the
+// jobs that it receives and emits under the provided name. 
+// Components returned for the same name share their statistics,
+// which is useful for workers of a load balancer.
+func (m *Monitor) Stage(name string, worker Component) Component {
+    stats := m.stage(name)
+    return func (in <-chan Job, out chan<- Job) {
+        instance := stats.start()
+        toWorker := GetChan()
+        fromWorker := GetChan()
+        go worker(toWorker, fromWorker)
+
+        go func () {
+            for job := range in {
+                stats.receive(job, m.clock.Now())
+                toWorker <- job
+            }
+            close(toWorker)
+        }()
+
+        for job := range fromWorker {
+            stats.emit(job, instance, m.clock.Now())
+            out <- job
+            stats.unblock(instance)
+        }
+        close(out)
+    }
+}
+
+// LinkComponents behaves like the LinkComponents function, but 
+// monitors every component as a separate stage, named after the 
+// function of the component and its position in the chain.
+func (m *Monitor) LinkComponents(components ...Component) Component {
+    stages := make([]Component, len(components))
+    for i, c := range components {
+        stages[i] = m.Stage(fmt.Sprintf("%s[%d]", funcName(c), i), c)
+    }
+    return LinkComponents(stages...)
+}
+
+// stage returns the statistics of the stage with the provided 
+// name, adding the stage if it doesn't exist.
+func (m *Monitor) stage(name string) *stageStats {
+    m.lock.Lock()
+    defer m.lock.Unlock()
+    for _, stats := range m.stages {
+        if (stats.name == name) {
+            return stats
+        }
+    }
+    stats := &stageStats{
+        name: name, 
+        held: make(map[Job]time.Time),
+        lastEmission: m.clock.Now(),
+        blocked: make(map[int]time.Time),
+    }
+    m.stages = append(m.stages, stats)
+    return stats
+}
+
+// start returns the id of a new instance of the stage.
+func (s *stageStats) start() int {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+    s.instances++
+    return s.instances
+}
+
+func (s *stageStats) receive(job Job, now time.Time) {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+    s.received++
+    s.held[job] = now
+}
+
+// emit records that the job left the instance of the stage. The
+// output of the instance counts as blocked until unblock is 
+// called for it.
+func (s *stageStats) emit(job Job, instance int, now time.Time) {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+    s.emitted++
+    delete(s.held, job)
+    s.lastEmission = now
+    s.blocked[instance] = now
+}
+
+func (s *stageStats) unblock(instance int) {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+    delete(s.blocked, instance)
+}
+
+// snapshot returns the current state of the stage.
+func (s *stageStats) snapshot(now time.Time, 
+                              threshold time.Duration) StageStats {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+    res := StageStats{
+        Name: s.name, Received: s.received, Emitted: s.emitted,
+        InFlight: len(s.held), 
+        SinceEmission: Duration(now.Sub(s.lastEmission)),
+    }
+    for _, arrival := range s.held {
+        if age := Duration(now.Sub(arrival)); age > res.OldestJob {
+            res.OldestJob = age
+        }
+    }
+    res.BlockedOutputs = len(s.blocked)
+    for _, since := range s.blocked {
+        if blocked := Duration(now.Sub(since)); blocked > res.OutputBlocked {
+            res.OutputBlocked = blocked
+        }
+    }
+    res.Stuck = res.InFlight > 0 && 
+        time.Duration(res.OldestJob) > threshold &&
+        time.Duration(res.SinceEmission) > threshold &&
+        time.Duration(res.OutputBlocked) <= threshold
+    return res
+}
+
+// Report returns the current state of all stages of the monitor.
+func (m *Monitor) Report() MonitorReport {
+    m.lock.Lock()
+    stages := append([]*stageStats(nil), m.stages...)
+    m.lock.Unlock()
+
+    now := m.clock.Now()
+    var report MonitorReport
+    holding := false
+    progressing := false
+    for _, stats := range stages {
+        snapshot := stats.snapshot(now, m.threshold)
+        report.Stages = append(report.Stages, snapshot)
+        if (snapshot.Stuck) {
+            report.Stuck = append(report.Stuck, snapshot.Name)
+        }
+        if (snapshot.InFlight > 0) {
+            holding = true
+        }
+        if (time.Duration(snapshot.SinceEmission) <= m.threshold) {
+            progressing = true
+        }
+    }
+    report.Deadlocked = holding && !progressing
+    return report
+}
+
+// Handler returns an http.Handler that serves the report of the
+// monitor as JSON.
+func (m *Monitor) Handler() http.Handler {
+    return http.HandlerFunc(func (w http.ResponseWriter, 
+                                  r *http.Request) {
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(m.Report())
+    })
+}
+
+// Watch checks the stages of the monitor every interval and logs
+// the stuck stages and deadlocks using the logger of the monitor.
+// It returns when the shutDown channel is closed or a value is 
+// sent on it.
+func (m *Monitor) Watch(interval time.Duration, shutDown <-chan bool) {
+    for {
+        timer := m.clock.NewTimer(interval)
+        select {
+            case <- shutDown: {
+                timer.Stop()
+                return
+            }
+            case <- timer.C(): {}
+        }
+        report := m.Report()
+        for _, stats := range report.Stages {
+            if (stats.Stuck) {
+                m.getLogger().Warn("pipeline stage is stuck",
+                    "stage", stats.Name, "in_flight", stats.InFlight,
+                    "oldest_job", time.Duration(stats.OldestJob), 
+                    "since_emission", 
+                    time.Duration(stats.SinceEmission))
+            }
+        }
+        if (report.Deadlocked) {
+            m.getLogger().Error("pipeline is deadlocked", 
+                "stuck", report.Stuck)
+        }
+    }
+}
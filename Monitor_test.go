@@ -0,0 +1,91 @@
+package mpserver_test
+
+import (
+    "testing"
+    "time"
+    "mpserver"
+    "mpserver/mpservertest"
+)
+
+func TestMonitorStuckStage(t *testing.T) {
+    clock := mpservertest.NewFakeClock(time.Now())
+    m := mpserver.NewMonitorWithClock(time.Second, clock)
+    release := make(chan bool)
+    // Holds every job until it is released.
+    holder := func (in <-chan mpserver.Job, out chan<- mpserver.Job) {
+        for job := range in {
+            <- release
+            out <- job
+        }
+        close(out)
+    }
+    identity := mpservertest.Identity
+    c := m.LinkComponents(identity, holder, identity)
+    in := mpserver.GetChan()
+    out := mpserver.GetChan()
+    go c(in, out)
+
+    in <- mpservertest.NewGetJob()
+    eventually(t, "Job didn't reach the holder.", func () bool {
+        return m.Report().Stages[1].InFlight == 1
+    })
+    clock.Advance(2 * time.Second)
+    report := m.Report()
+    if (len(report.Stuck) != 1 || report.Stuck[0] != report.Stages[1].Name) {
+        t.Errorf("Stuck stages are %v, want the holder.", report.Stuck)
+    }
+    if (!report.Deadlocked) {
+        t.Error("Pipeline isn't reported as deadlocked.")
+    }
+
+    release <- true
+    <- out
+    report = m.Report()
+    if (len(report.Stuck) != 0 || report.Deadlocked) {
+        t.Errorf("Pipeline is reported stuck after it made progress: %+v", 
+            report)
+    }
+    close(in)
+    for _ = range out {}
+}
+
+func TestMonitorBlockedInstances(t *testing.T) {
+    clock := mpservertest.NewFakeClock(time.Now())
+    m := mpserver.NewMonitorWithClock(time.Second, clock)
+    // Two instances of a stage share its statistics.
+    first := m.Stage("worker", mpservertest.Identity)
+    second := m.Stage("worker", mpservertest.Identity)
+    in1, out1 := mpserver.GetChan(), mpserver.GetChan()
+    in2, out2 := mpserver.GetChan(), mpserver.GetChan()
+    go first(in1, out1)
+    go second(in2, out2)
+
+    // The output of the first instance isn't read, the output of
+    // the second one is.
+    in1 <- mpservertest.NewGetJob()
+    eventually(t, "First instance didn't emit.", func () bool {
+        return m.Report().Stages[0].BlockedOutputs == 1
+    })
+    in2 <- mpservertest.NewGetJob()
+    <- out2
+    eventually(t, "Second instance wasn't unblocked.", func () bool {
+        stats := m.Report().Stages[0]
+        return stats.Emitted == 2 && stats.BlockedOutputs == 1
+    })
+
+    clock.Advance(2 * time.Second)
+    stats := m.Report().Stages[0]
+    if (stats.BlockedOutputs != 1) {
+        t.Errorf("%d blocked outputs, want 1.", stats.BlockedOutputs)
+    }
+    if (time.Duration(stats.OutputBlocked) != 2 * time.Second) {
+        t.Errorf("Output blocked for %v, want 2s.", 
+            time.Duration(stats.OutputBlocked))
+    }
+
+    <- out1
+    close(in1)
+    close(in2)
+    for _ = range out1 {}
+    for _ = range out2 {}
+}
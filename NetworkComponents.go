@@ -21,14 +21,17 @@ func NetworkComponent(client *http.Client) Component {
 		"No request provided to Network Component.")
 	return func (in <-chan Job, out chan<- Job) {
 		for job := range in {
+			if skipCancelled(job) {
+				out <- job
+				continue
+			}
 			req, ok := job.GetResult().(*http.Request)
 			if !ok {
 				job.SetResult(inputError)
 				out <- job
 				continue
 			}
-			// Perform the request using the client.
-			resp, err := client.Do(req)
+			resp, err := doRequest(client, job, req)
 			if err != nil {
 				// Request wasn't successful.
 				job.SetResult(err)
@@ -42,6 +45,18 @@ func NetworkComponent(client *http.Client) Component {
 	}
 }
 
+// doRequest performs the request of the job using the client. 
+// The request is aborted if the client of the job goes away. It
+// is copied, as it might be shared between jobs.
+func doRequest(client *http.Client, job Job, 
+			   req *http.Request) (*http.Response, error) {
+	req = req.Clone(job.Context())
+	if req.Header.Get(RequestIDHeader) == "" {
+		req.Header.Set(RequestIDHeader, job.RequestID())
+	}
+	return client.Do(req)
+}
+
 // readResponse is a helper function read the body of 
 // http.Response.
 func readResponse(resp *http.Response) ([]byte, error) {
@@ -68,6 +83,8 @@ func RequestRewriter(scheme, host string) Component {
 			// make perform the Request again.
 			request.RequestURI = ""
 			request.Host = ""
+			// Forward the request id to the host.
+			request.Header.Set(RequestIDHeader, job.RequestID())
 			job.SetResult(request)
 			out <- job
 		}
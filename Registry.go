@@ -0,0 +1,397 @@
+package mpserver
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "sync"
+    "time"
+)
+
+// Spec describes a Component or a Writer in a configuration 
+// file. Type is the name under which its factory is registered
+// and Args are its arguments. Components that wrap other 
+// components, such as load balancers or the cache, take them 
+// from Stages, which are linked together. Writers that wrap 
+// another writer take it from Writer.
+type Spec struct {
+    Type string `json:"type"`
+    Args map[string]json.RawMessage `json:"args,omitempty"`
+    Stages []Spec `json:"stages,omitempty"`
+    Writer *Spec `json:"writer,omitempty"`
+}
+
+// Arg decodes the argument with the provided key into value. If
+// the argument is not present value is left unchanged.
+func (spec Spec) Arg(key string, value interface{}) error {
+    raw, ok := spec.Args[key]
+    if (!ok) {
+        return nil
+    }
+    if err := json.Unmarshal(raw, value); err != nil {
+        return fmt.Errorf("%s: argument %q: %v", spec.Type, key, err)
+    }
+    return nil
+}
+
+// Duration returns the argument with the provided key parsed as
+// a duration, such as "1m30s", or def if it is not present.
+func (spec Spec) Duration(key string, def time.Duration) (
+    time.Duration, error) {
+    s := ""
+    if err := spec.Arg(key, &s); err != nil || s == "" {
+        return def, err
+    }
+    d, err := time.ParseDuration(s)
+    if (err != nil) {
+        return def, fmt.Errorf("%s: argument %q: %v", spec.Type, key, err)
+    }
+    return d, nil
+}
+
+// positive returns an error if the value of the argument with the
+// provided key isn't positive.
+func (spec Spec) positive(key string, value int) error {
+    if (value <= 0) {
+        return fmt.Errorf("%s: argument %q must be positive, got %d.",
+            spec.Type, key, value)
+    }
+    return nil
+}
+
+// ComponentFactory creates a Component from its Spec. The loader
+// provides access to named storages, registered states and to 
+// building nested stages.
+type ComponentFactory func (spec Spec, l *Loader) (Component, error)
+
+// WriterFactory creates a Writer from its Spec.
+type WriterFactory func (spec Spec, l *Loader) (Writer, error)
+
+// Registry maps names to factories of Components and Writers and
+// to initial States of sessions, so that pipelines can be built
+// from configuration files. It is safe for concurrent use.
+type Registry struct {
+    lock sync.RWMutex
+    components map[string]ComponentFactory
+    writers map[string]WriterFactory
+    states map[string]State
+}
+
+// NewRegistry returns a Registry that contains the Components and
+// Writers of this package.
+func NewRegistry() *Registry {
+    r := &Registry{
+        components: make(map[string]ComponentFactory),
+        writers: make(map[string]WriterFactory),
+        states: make(map[string]State),
+    }
+    registerBuiltins(r)
+    return r
+}
+
+// DefaultRegistry is the registry used by LoadServer.
+var DefaultRegistry = NewRegistry()
+
+// RegisterComponent registers the component factory under the 
+// provided name, replacing any previous registration.
+func (r *Registry) RegisterComponent(name string, f ComponentFactory) {
+    r.lock.Lock()
+    defer r.lock.Unlock()
+    r.components[name] = f
+}
+
+// RegisterWriter registers the writer factory under the provided
+// name, replacing any previous registration.
+func (r *Registry) RegisterWriter(name string, f WriterFactory) {
+    r.lock.Lock()
+    defer r.lock.Unlock()
+    r.writers[name] = f
+}
+
+// RegisterState registers the initial state of sessions under the
+// provided name, so that it can be used by SessionManager stages.
+func (r *Registry) RegisterState(name string, initial State) {
+    r.lock.Lock()
+    defer r.lock.Unlock()
+    r.states[name] = initial
+}
+
+// RegisterComponent registers the component factory in the 
+// DefaultRegistry.
+func RegisterComponent(name string, f ComponentFactory) {
+    DefaultRegistry.RegisterComponent(name, f)
+}
+
+// RegisterWriter registers the writer factory in the 
+// DefaultRegistry.
+func RegisterWriter(name string, f WriterFactory) {
+    DefaultRegistry.RegisterWriter(name, f)
+}
+
+// RegisterState registers the initial state in the 
+// DefaultRegistry.
+func RegisterState(name string, initial State) {
+    DefaultRegistry.RegisterState(name, initial)
+}
+
+// Loader builds Components and Writers from their Specs using a
+// Registry. It holds the storages shared by the stages that it 
+// builds.
+type Loader struct {
+    registry *Registry
+    storages map[string]Storage
+}
+
+// NewLoader returns a Loader that uses the provided registry and
+// storages.
+func NewLoader(registry *Registry, storages map[string]Storage) *Loader {
+    if (storages == nil) {
+        storages = make(map[string]Storage)
+    }
+    return &Loader{registry, storages}
+}
+
+// Storage returns the storage with the provided name.
+func (l *Loader) Storage(name string) (Storage, error) {
+    storage, ok := l.storages[name]
+    if (!ok) {
+        return nil, fmt.Errorf("Unknown storage %q.", name)
+    }
+    return storage, nil
+}
+
+// State returns the initial state registered under the provided
+// name.
+func (l *Loader) State(name string) (State, error) {
+    l.registry.lock.RLock()
+    defer l.registry.lock.RUnlock()
+    state, ok := l.registry.states[name]
+    if (!ok) {
+        return nil, fmt.Errorf("Unknown state %q.", name)
+    }
+    return state, nil
+}
+
+// Component builds the component described by the spec.
+func (l *Loader) Component(spec Spec) (Component, error) {
+    l.registry.lock.RLock()
+    f, ok := l.registry.components[spec.Type]
+    l.registry.lock.RUnlock()
+    if (!ok) {
+        return nil, fmt.Errorf("Unknown component %q.", spec.Type)
+    }
+    return f(spec, l)
+}
+
+// Stages builds the components described by the specs and links
+// them together.
+func (l *Loader) Stages(specs []Spec) (Component, error) {
+    if (len(specs) == 0) {
+        return nil, errors.New("No stages provided.")
+    }
+    components := make([]Component, len(specs))
+    for i, spec := range specs {
+        c, err := l.Component(spec)
+        if (err != nil) {
+            return nil, err
+        }
+        components[i] = c
+    }
+    return LinkComponents(components...), nil
+}
+
+// Writer builds the writer described by the spec.
+func (l *Loader) Writer(spec Spec) (Writer, error) {
+    l.registry.lock.RLock()
+    f, ok := l.registry.writers[spec.Type]
+    l.registry.lock.RUnlock()
+    if (!ok) {
+        return nil, fmt.Errorf("Unknown writer %q.", spec.Type)
+    }
+    return f(spec, l)
+}
+
+// nestedWriter builds the writer wrapped by the spec.
+func (l *Loader) nestedWriter(spec Spec) (Writer, error) {
+    if (spec.Writer == nil) {
+        return nil, fmt.Errorf("%s: no writer provided.", spec.Type)
+    }
+    return l.Writer(*spec.Writer)
+}
+
+// registerBuiltins registers the Components and Writers of this
+// package in the registry.
+func registerBuiltins(r *Registry) {
+    constant := func (c Component) ComponentFactory {
+        return func (Spec, *Loader) (Component, error) {
+            return c, nil
+        }
+    }
+    r.RegisterComponent("FileComponent", constant(FileComponent))
+    r.RegisterComponent("ResponseReader", constant(ResponseReader))
+    r.RegisterComponent("PathMaker", 
+        func (spec Spec, l *Loader) (Component, error) {
+            var dir, prefix string
+            err := errors.Join(
+                spec.Arg("dir", &dir), spec.Arg("prefix", &prefix))
+            return PathMaker(dir, prefix), err
+        })
+    r.RegisterComponent("ConstantComponent", 
+        func (spec Spec, l *Loader) (Component, error) {
+            var value interface{}
+            err := spec.Arg("value", &value)
+            return ConstantComponent(value), err
+        })
+    r.RegisterComponent("RequestRewriter", 
+        func (spec Spec, l *Loader) (Component, error) {
+            scheme, host := "http", ""
+            err := errors.Join(
+                spec.Arg("scheme", &scheme), spec.Arg("host", &host))
+            return RequestRewriter(scheme, host), err
+        })
+    r.RegisterComponent("NetworkComponent", 
+        func (spec Spec, l *Loader) (Component, error) {
+            timeout, err := spec.Duration("timeout", 0)
+            return NetworkComponent(&http.Client{Timeout: timeout}), err
+        })
+    r.RegisterComponent("ProxyComponent", 
+        func (spec Spec, l *Loader) (Component, error) {
+            scheme, host := "http", ""
+            timeout, err := spec.Duration("timeout", 0)
+            err = errors.Join(err,
+                spec.Arg("scheme", &scheme), spec.Arg("host", &host))
+            if (host == "") {
+                err = errors.Join(err, errors.New(
+                    "ProxyComponent: no host provided."))
+            }
+            return ProxyComponent(scheme, host, 
+                &http.Client{Timeout: timeout}), err
+        })
+    r.RegisterComponent("CacheComponent", 
+        func (spec Spec, l *Loader) (Component, error) {
+            name := ""
+            expiration, err := spec.Duration("expiration", time.Minute)
+            err = errors.Join(err, spec.Arg("storage", &name))
+            storage, storageErr := l.Storage(name)
+            worker, workerErr := l.Stages(spec.Stages)
+            err = errors.Join(err, storageErr, workerErr)
+            if (err != nil) {
+                return nil, err
+            }
+            return CacheComponent(storage, worker, expiration), nil
+        })
+    r.RegisterComponent("SessionManager", 
+        func (spec Spec, l *Loader) (Component, error) {
+            storageName, stateName := "", ""
+            expiration, err := spec.Duration("expiration", 0)
+            err = errors.Join(err, spec.Arg("storage", &storageName),
+                spec.Arg("state", &stateName))
+            storage, storageErr := l.Storage(storageName)
+            state, stateErr := l.State(stateName)
+            err = errors.Join(err, storageErr, stateErr)
+            if (err != nil) {
+                return nil, err
+            }
+            return SessionManager(storage, state, expiration), nil
+        })
+    r.RegisterComponent("ErrorPasser", 
+        func (spec Spec, l *Loader) (Component, error) {
+            worker, err := l.Stages(spec.Stages)
+            if (err != nil) {
+                return nil, err
+            }
+            return ErrorPasser(worker), nil
+        })
+    r.RegisterComponent("PannicHandler", 
+        func (spec Spec, l *Loader) (Component, error) {
+            worker, err := l.Stages(spec.Stages)
+            if (err != nil) {
+                return nil, err
+            }
+            return PannicHandler(worker), nil
+        })
+    r.RegisterComponent("StaticLoadBalancer", 
+        func (spec Spec, l *Loader) (Component, error) {
+            workers := 1
+            worker, err := l.Stages(spec.Stages)
+            err = errors.Join(err, spec.Arg("workers", &workers))
+            if (err == nil) {
+                err = spec.positive("workers", workers)
+            }
+            if (err != nil) {
+                return nil, err
+            }
+            return StaticLoadBalancer(worker, workers), nil
+        })
+    r.RegisterComponent("DynamicLoadBalancer", 
+        func (spec Spec, l *Loader) (Component, error) {
+            maxWorkers := 1
+            add, addErr := spec.Duration("addTimeout", time.Second)
+            remove, removeErr := spec.Duration(
+                "removeTimeout", time.Minute)
+            worker, err := l.Stages(spec.Stages)
+            err = errors.Join(err, addErr, removeErr, 
+                spec.Arg("maxWorkers", &maxWorkers))
+            if (err == nil) {
+                err = spec.positive("maxWorkers", maxWorkers)
+            }
+            if (err != nil) {
+                return nil, err
+            }
+            return DynamicLoadBalancer(
+                worker, maxWorkers, add, remove), nil
+        })
+
+    writer := func (w Writer) WriterFactory {
+        return func (Spec, *Loader) (Writer, error) {
+            return w, nil
+        }
+    }
+    r.RegisterWriter("ErrorWriter", writer(ErrorWriter))
+    r.RegisterWriter("StringWriter", writer(StringWriter))
+    r.RegisterWriter("JsonWriter", writer(JsonWriter))
+    r.RegisterWriter("GzipWriter", writer(GzipWriter))
+    r.RegisterWriter("GenericWriter", writer(GenericWriter))
+    r.RegisterWriter("ResponseWriter", writer(ResponseWriter))
+    r.RegisterWriter("HttpResponseWriter", writer(HttpResponseWriter))
+    r.RegisterWriter("WebSocketWriter", writer(WebSocketWriter))
+    r.RegisterWriter("FileServerWriter", 
+        func (spec Spec, l *Loader) (Writer, error) {
+            var dir, prefix string
+            err := errors.Join(
+                spec.Arg("dir", &dir), spec.Arg("prefix", &prefix))
+            return FileServerWriter(dir, prefix), err
+        })
+    r.RegisterWriter("StaticLoadBalancerWriter", 
+        func (spec Spec, l *Loader) (Writer, error) {
+            workers := 1
+            w, err := l.nestedWriter(spec)
+            err = errors.Join(err, spec.Arg("workers", &workers))
+            if (err == nil) {
+                err = spec.positive("workers", workers)
+            }
+            if (err != nil) {
+                return nil, err
+            }
+            return StaticLoadBalancerWriter(w, workers), nil
+        })
+    r.RegisterWriter("DynamicLoadBalancerWriter", 
+        func (spec Spec, l *Loader) (Writer, error) {
+            maxWorkers := 1
+            add, addErr := spec.Duration("addTimeout", time.Second)
+            remove, removeErr := spec.Duration(
+                "removeTimeout", time.Minute)
+            w, err := l.nestedWriter(spec)
+            err = errors.Join(err, addErr, removeErr, 
+                spec.Arg("maxWorkers", &maxWorkers))
+            if (err == nil) {
+                err = spec.positive("maxWorkers", maxWorkers)
+            }
+            if (err != nil) {
+                return nil, err
+            }
+            return DynamicLoadBalancerWriter(
+                w, maxWorkers, add, remove), nil
+        })
+}
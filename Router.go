@@ -37,7 +37,8 @@ func ToInChans(chans [](chan Job)) []<-chan Job {
 // the job is written to a corresponding output channel and the 
 // processing of the current job terminates. If all conditions 
 // return false then the job is written to the default output 
-// channel.
+// channel. When the input channel is closed, the Router closes
+// all of its output channels.
 func Router(in <-chan Job, defOut chan<- Job, 
             outs []chan<- Job, conds []Condition) {
     if len(outs) != len(conds) {
@@ -61,6 +62,7 @@ func Router(in <-chan Job, defOut chan<- Job,
     for _, ch := range outs {
         close(ch)
     }
+    close(defOut)
 }
 
 // ErrorRouter reads jobs from its input channel and sends
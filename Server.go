@@ -0,0 +1,408 @@
+package mpserver
+
+import (
+    "context"
+    "errors"
+    "net"
+    "net/http"
+    "sync"
+    "golang.org/x/net/websocket"
+)
+
+// Server is a web-server that owns the http.Server, the input 
+// channels of the registered pipelines and the writers at their
+// ends. This allows it to shut down gracefully, that is to stop
+// accepting new requests and to let the pipelines drain using 
+// the close propagation of the Components.
+type Server struct {
+    mux *http.ServeMux
+    server *http.Server
+    config ServerConfig
+    logger Logger
+    certs *CertReloader
+    listeners []net.Listener
+
+    lock sync.RWMutex
+    closing bool
+    inputs []chan<- Job
+    swappable []*SwapHandler
+    webSockets map[*websocket.Conn]bool // Open connections.
+    closeInputs sync.Once
+    shutdownHooks []func ()
+
+    handlers sync.WaitGroup // Handlers that are in progress.
+    writers sync.WaitGroup  // Writers that are running.
+}
+
+// NewServer returns a Server that listens on the provided 
+// address once its ListenAndServe method is called.
+func NewServer(addr string) *Server {
+    return NewServerConfig(addr, ServerConfig{})
+}
+
+// NewServerConfig returns a Server that listens on the provided
+// address and enforces the timeouts and limits of the provided
+// config.
+func NewServerConfig(addr string, config ServerConfig) *Server {
+    s := &Server{mux: http.NewServeMux(), config: config}
+    s.server = config.httpServer(addr, s)
+    return s
+}
+
+// ServeHTTP dispatches the request to the handler registered for
+// its url. Requests that arrive after shutdown was initiated are
+// rejected with the 503 response code.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    s.lock.RLock()
+    if (s.closing) {
+        s.lock.RUnlock()
+        http.Error(w, "Server is shutting down.", 
+            http.StatusServiceUnavailable)
+        return
+    }
+    s.handlers.Add(1)
+    s.lock.RUnlock()
+
+    defer s.handlers.Done()
+    s.mux.ServeHTTP(w, r)
+}
+
+// SetLogger sets the logger used by the server and by the jobs 
+// of pipelines that are registered after this call, unless they
+// configure their own logger.
+func (s *Server) SetLogger(logger Logger) {
+    s.logger = logger
+}
+
+// getLogger returns the logger of the server or the default 
+// logger if it isn't set.
+func (s *Server) getLogger() Logger {
+    if (s.logger == nil) {
+        return DefaultLogger()
+    }
+    return s.logger
+}
+
+// onShutdown registers a function that is called after the 
+// pipelines of the server terminated on Shutdown.
+func (s *Server) onShutdown(f func ()) {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+    s.shutdownHooks = append(s.shutdownHooks, f)
+}
+
+// routeOptions returns the provided handler options preceded by
+// the defaults of the server for the provided url.
+func (s *Server) routeOptions(url string, 
+                              opts []HandlerOption) []HandlerOption {
+    return append([]HandlerOption{WithName(url), 
+        WithLogger(s.logger), 
+        WithMaxBodyBytes(s.config.MaxBodyBytes)}, opts...)
+}
+
+// Listen registers a handler for the provided url, that will for
+// each incoming request create a Job object and send it to the 
+// provided input channel of a pipeline. The server closes the 
+// channel when it shuts down.
+func (s *Server) Listen(url string, in chan<- Job, 
+                        opts ...HandlerOption) {
+    s.listen(url, in, "", opts)
+}
+
+// listen registers the handler as Listen does. If strip isn't
+// empty, it is removed from the URL path of requests before they
+// are passed to the pipeline.
+func (s *Server) listen(url string, in chan<- Job, strip string,
+                        opts []HandlerOption) {
+    s.lock.Lock()
+    s.inputs = append(s.inputs, in)
+    s.lock.Unlock()
+    var h http.Handler = Handler(in, s.routeOptions(url, opts)...)
+    if (strip != "") {
+        h = http.StripPrefix(strip, h)
+    }
+    s.mux.Handle(url, h)
+}
+
+// WebSocketListen registers a WebSocket handler for the provided 
+// url, that will for each event on the accepted connections 
+// create a Job object and send it to the provided input channel.
+// The open connections are closed when the server shuts down, 
+// which makes their handlers send the close events and return.
+func (s *Server) WebSocketListen(url string, in chan<- Job) {
+    s.lock.Lock()
+    s.inputs = append(s.inputs, in)
+    s.lock.Unlock()
+    s.mux.Handle(url, webSocketHandler(in, s.trackWebSocket))
+}
+
+// trackWebSocket records the open connection, so that it can be 
+// closed on shutdown. It returns false if the server is already
+// shutting down.
+func (s *Server) trackWebSocket(conn *websocket.Conn) (func (), bool) {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+    if (s.closing) {
+        return nil, false
+    }
+    if (s.webSockets == nil) {
+        s.webSockets = make(map[*websocket.Conn]bool)
+    }
+    s.webSockets[conn] = true
+    return func () {
+        s.lock.Lock()
+        defer s.lock.Unlock()
+        delete(s.webSockets, conn)
+    }, true
+}
+
+// Start starts the provided writer with the provided input 
+// channel in a new goroutine. The server waits for the writer 
+// to terminate when it shuts down.
+func (s *Server) Start(writer Writer, in <-chan Job) {
+    s.writers.Add(1)
+    go func () {
+        defer s.writers.Done()
+        writer(in)
+    }()
+}
+
+// Route creates a pipeline for the provided url that consists 
+// of the provided writer. That is it creates a channel, starts
+// the writer on it and registers a handler that sends jobs to
+// it.
+func (s *Server) Route(url string, writer Writer, 
+                       opts ...HandlerOption) {
+    in := GetChan()
+    s.Start(writer, in)
+    s.Listen(url, in, opts...)
+}
+
+// AddListener adds a listener on which the server accepts 
+// connections once Serve or ServeTLS is called. All listeners 
+// feed the same pipelines and are closed on Shutdown.
+func (s *Server) AddListener(l net.Listener) {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+    s.listeners = append(s.listeners, l)
+}
+
+// ListenTCP adds a listener on the provided TCP address.
+func (s *Server) ListenTCP(addr string) error {
+    l, err := net.Listen("tcp", addr)
+    if (err != nil) {
+        return err
+    }
+    s.AddListener(l)
+    return nil
+}
+
+// ListenUnix adds a listener on the Unix domain socket with the
+// provided path. The socket file is removed on Shutdown.
+func (s *Server) ListenUnix(path string) error {
+    l, err := net.Listen("unix", path)
+    if (err != nil) {
+        return err
+    }
+    s.AddListener(l)
+    return nil
+}
+
+// ListenInherited adds the listeners passed to the process by 
+// socket activation. See InheritedListeners.
+func (s *Server) ListenInherited() error {
+    listeners, err := InheritedListeners()
+    if (err != nil) {
+        return err
+    }
+    for _, l := range listeners {
+        s.AddListener(l)
+    }
+    return nil
+}
+
+// serve calls the provided function for every listener of the 
+// server in a separate goroutine. If no listeners were added, 
+// the server listens on its address or the provided default
+// address. When serving on a listener fails, the server stops
+// serving on all listeners and the error is returned.
+func (s *Server) serve(defaultAddr string, 
+                       serveOne func (net.Listener) error) error {
+    s.lock.RLock()
+    empty := len(s.listeners) == 0
+    s.lock.RUnlock()
+    if (empty) {
+        addr := s.server.Addr
+        if (addr == "") {
+            addr = defaultAddr
+        }
+        if err := s.ListenTCP(addr); err != nil {
+            return err
+        }
+    }
+
+    s.lock.RLock()
+    listeners := s.listeners
+    s.lock.RUnlock()
+    errs := make(chan error, len(listeners))
+    for _, l := range listeners {
+        s.getLogger().Info("listening", "addr", l.Addr().String(),
+            "network", l.Addr().Network())
+        go func (l net.Listener) {
+            errs <- serveOne(l)
+        }(l)
+    }
+
+    result := http.ErrServerClosed
+    for range listeners {
+        if err := <- errs; err != http.ErrServerClosed && 
+            result == http.ErrServerClosed {
+            result = err
+            s.server.Close()
+        }
+    }
+    return result
+}
+
+// Serve starts accepting connections on all listeners of the 
+// server. It always returns a non-nil error. After Shutdown it 
+// returns http.ErrServerClosed.
+func (s *Server) Serve() error {
+    return s.serve(":http", s.server.Serve)
+}
+
+// SwapRoute creates a pipeline for the provided url, that 
+// consists of the provided writer and can be replaced at runtime 
+// using the Swap method of the returned SwapHandler. The server
+// drains the pipeline when it shuts down.
+func (s *Server) SwapRoute(url string, writer Writer, 
+                           opts ...HandlerOption) *SwapHandler {
+    h := NewSwapHandler(writer, s.routeOptions(url, opts)...)
+    s.lock.Lock()
+    s.swappable = append(s.swappable, h)
+    s.lock.Unlock()
+    s.mux.Handle(url, h)
+    return h
+}
+
+// ListenAndServe starts accepting connections on the address of
+// the server, or on its listeners if any were added. It always 
+// returns a non-nil error. After Shutdown it returns 
+// http.ErrServerClosed.
+func (s *Server) ListenAndServe() error {
+    return s.Serve()
+}
+
+// ServeTLS starts accepting HTTPS connections on all listeners 
+// of the server according to the provided TLSConfig. The 
+// certificates are reloaded when their files change until the 
+// server is shut down. It can only be called once. It always 
+// returns a non-nil error. After Shutdown it returns 
+// http.ErrServerClosed.
+func (s *Server) ServeTLS(tlsConfig TLSConfig) error {
+    s.lock.Lock()
+    if (s.closing) {
+        s.lock.Unlock()
+        return http.ErrServerClosed
+    }
+    if (s.certs != nil) {
+        s.lock.Unlock()
+        return errors.New("Server is already serving HTTPS.")
+    }
+    certs, err := tlsConfig.configure(s.server, s.getLogger())
+    if (err != nil) {
+        s.lock.Unlock()
+        return err
+    }
+    s.certs = certs
+    s.lock.Unlock()
+
+    return s.serve(":https", func (l net.Listener) error {
+        return s.server.ServeTLS(l, "", "")
+    })
+}
+
+// ListenAndServeTLS starts accepting HTTPS connections on the 
+// address of the server, or on its listeners if any were added.
+// See ServeTLS.
+func (s *Server) ListenAndServeTLS(tlsConfig TLSConfig) error {
+    return s.ServeTLS(tlsConfig)
+}
+
+// waitGroup waits for the provided wait group to finish or the 
+// context to be done, whichever happens first.
+func waitGroup(wg *sync.WaitGroup, ctx context.Context) error {
+    finished := make(chan bool)
+    go func () {
+        wg.Wait()
+        close(finished)
+    }()
+    select {
+        case <- finished: return nil
+        case <- ctx.Done(): return ctx.Err()
+    }
+}
+
+// Shutdown gracefully shuts down the server. It stops accepting
+// new connections and waits for all in-flight requests to be
+// written. Then it closes the input channels of the pipelines,
+// so that all Components and load balancers terminate, and waits
+// for the writers to terminate. If the context is done before 
+// that, Shutdown returns the context error and the pipelines
+// are left running.
+func (s *Server) Shutdown(ctx context.Context) error {
+    s.lock.Lock()
+    s.closing = true
+    if (s.certs != nil) {
+        s.certs.Close()
+    }
+    // Closing the WebSocket connections makes their handlers 
+    // return, the http.Server doesn't track hijacked connections.
+    for conn := range s.webSockets {
+        conn.Close()
+    }
+    s.lock.Unlock()
+
+    if err := s.server.Shutdown(ctx); err != nil {
+        return err
+    }
+    // Hijacked connections are not tracked by the http.Server, 
+    // so wait for all handlers explicitly.
+    if err := waitGroup(&s.handlers, ctx); err != nil {
+        return err
+    }
+
+    // No handler can send jobs now, so it is safe to close the
+    // input channels.
+    s.closeInputs.Do(func () {
+        s.lock.RLock()
+        defer s.lock.RUnlock()
+        // The same channel can be registered for several urls.
+        closed := make(map[chan<- Job]bool)
+        for _, in := range s.inputs {
+            if (!closed[in]) {
+                closed[in] = true
+                close(in)
+            }
+        }
+        for _, h := range s.swappable {
+            s.writers.Add(1)
+            go func (h *SwapHandler) {
+                defer s.writers.Done()
+                h.Close()
+            }(h)
+        }
+    })
+    if err := waitGroup(&s.writers, ctx); err != nil {
+        return err
+    }
+
+    s.lock.Lock()
+    hooks := s.shutdownHooks
+    s.shutdownHooks = nil
+    s.lock.Unlock()
+    for _, hook := range hooks {
+        hook()
+    }
+    return nil
+}
@@ -0,0 +1,114 @@
+package mpserver
+
+import (
+    "bytes"
+    "io"
+    "net/http"
+    "time"
+)
+
+// ServerConfig specifies the timeouts and limits of a server. 
+// Zero values mean that the corresponding limit is not enforced,
+// apart from MaxHeaderBytes, for which the default of the http
+// package is used.
+type ServerConfig struct {
+    // ReadTimeout is the maximum time for reading the entire 
+    // request, including the body.
+    ReadTimeout time.Duration
+
+    // ReadHeaderTimeout is the maximum time for reading the 
+    // request headers.
+    ReadHeaderTimeout time.Duration
+
+    // WriteTimeout is the maximum time from the end of reading 
+    // the request headers to the end of writing the response.
+    WriteTimeout time.Duration
+
+    // IdleTimeout is the maximum time to wait for the next 
+    // request on a keep-alive connection.
+    IdleTimeout time.Duration
+
+    // MaxHeaderBytes is the maximum size of the request headers.
+    MaxHeaderBytes int
+
+    // MaxBodyBytes is the maximum size of request bodies for all
+    // routes of a Server. It can be overridden for a single 
+    // route using the WithMaxBodyBytes option.
+    MaxBodyBytes int64
+}
+
+// httpServer returns an http.Server with the provided address 
+// and handler, that enforces the timeouts and limits of the 
+// config.
+func (config ServerConfig) httpServer(addr string, 
+                                      handler http.Handler) *http.Server {
+    return &http.Server{
+        Addr: addr,
+        Handler: handler,
+        ReadTimeout: config.ReadTimeout,
+        ReadHeaderTimeout: config.ReadHeaderTimeout,
+        WriteTimeout: config.WriteTimeout,
+        IdleTimeout: config.IdleTimeout,
+        MaxHeaderBytes: config.MaxHeaderBytes,
+    }
+}
+
+// ListenAndServeConfig behaves like ListenAndServe, but enforces
+// the timeouts and limits of the provided config. MaxBodyBytes 
+// is enforced only for handlers created with the 
+// WithMaxBodyBytes option.
+func ListenAndServeConfig(addr string, handler http.Handler, 
+                          config ServerConfig) error {
+    DefaultLogger().Info("listening", "addr", addr)
+    if (handler == nil) {
+        handler = DefaultServeMux
+    }
+    return config.httpServer(addr, handler).ListenAndServe()
+}
+
+// WithMaxBodyBytes returns a HandlerOption that limits the size
+// of request bodies. Requests with larger bodies are rejected 
+// with the 413 response code before their job enters the 
+// pipeline.
+func WithMaxBodyBytes(maxBytes int64) HandlerOption {
+    return func (config *handlerConfig) {
+        config.maxBodyBytes = maxBytes
+    }
+}
+
+// limitBody enforces the maximum body size on the request. If 
+// the length of the body is unknown, the body is read into 
+// memory to find out if it is too large. It returns false if 
+// the request was rejected.
+func limitBody(w http.ResponseWriter, r *http.Request, 
+               maxBytes int64) bool {
+    if (maxBytes <= 0 || r.Body == nil || r.Body == http.NoBody) {
+        return true
+    }
+
+    tooLarge := func () bool {
+        http.Error(w, "Request body too large.", 
+            http.StatusRequestEntityTooLarge)
+        return false
+    }
+    if (r.ContentLength > maxBytes) {
+        return tooLarge()
+    }
+    if (r.ContentLength >= 0) {
+        r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+        return true
+    }
+
+    body, err := io.ReadAll(io.LimitReader(r.Body, maxBytes+1))
+    r.Body.Close()
+    if (err != nil) {
+        http.Error(w, "Failed to read request body.", 
+            http.StatusBadRequest)
+        return false
+    }
+    if (int64(len(body)) > maxBytes) {
+        return tooLarge()
+    }
+    r.Body = io.NopCloser(bytes.NewReader(body))
+    return true
+}
@@ -0,0 +1,60 @@
+package mpserver_test
+
+import (
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "mpserver"
+)
+
+func TestMaxBodyBytes(t *testing.T) {
+    in := mpserver.GetChan()
+    out := mpserver.GetChan()
+    go mpserver.MakeComponent(func (job mpserver.Job) {
+        body, err := io.ReadAll(job.GetRequest().Body)
+        if (err != nil) {
+            job.SetResult(err)
+            return
+        }
+        job.SetResult(string(body))
+    })(in, out)
+    go mpserver.StringWriter(out)
+    defer close(in)
+    h := mpserver.Handler(in, mpserver.WithMaxBodyBytes(4))
+
+    tests := []struct {
+        name string
+        body string
+        unknownLength bool
+        code int
+    }{
+        {"below", "abc", false, http.StatusOK},
+        {"at limit", "abcd", false, http.StatusOK},
+        {"above", "abcde", false, http.StatusRequestEntityTooLarge},
+        {"unknown at limit", "abcd", true, http.StatusOK},
+        {"unknown above", "abcde", true,
+         http.StatusRequestEntityTooLarge},
+    }
+    for _, test := range tests {
+        r := httptest.NewRequest("POST", "/",
+                                 strings.NewReader(test.body))
+        if (test.unknownLength) {
+            r.ContentLength = -1
+            r.Body = io.NopCloser(strings.NewReader(test.body))
+        }
+        rec := httptest.NewRecorder()
+        h.ServeHTTP(rec, r)
+        if (rec.Code != test.code) {
+            t.Errorf("%s: response code is %d, want %d.",
+                     test.name, rec.Code, test.code)
+            continue
+        }
+        if (test.code == http.StatusOK &&
+            rec.Body.String() != test.body) {
+            t.Errorf("%s: body is %q, want %q.",
+                     test.name, rec.Body.String(), test.body)
+        }
+    }
+}
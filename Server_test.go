@@ -0,0 +1,132 @@
+package mpserver_test
+
+import (
+    "context"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "time"
+    "golang.org/x/net/websocket"
+    "mpserver"
+)
+
+// slowWriter returns a writer that responds with the provided 
+// string after the provided delay.
+func slowWriter(s string, delay time.Duration) mpserver.Writer {
+    return func (in <-chan mpserver.Job) {
+        out := mpserver.GetChan()
+        go mpserver.LinkComponents(
+            mpserver.MakeComponent(func (job mpserver.Job) {
+                time.Sleep(delay)
+            }), 
+            mpserver.ConstantComponent(s))(in, out)
+        mpserver.StringWriter(out)
+    }
+}
+
+// shutdown shuts the server down and fails the test if it 
+// doesn't terminate within a second.
+func shutdown(t *testing.T, s *mpserver.Server) {
+    t.Helper()
+    ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+    defer cancel()
+    if err := s.Shutdown(ctx); err != nil {
+        t.Fatal(err)
+    }
+}
+
+func TestServerShutdownDrainsPipelines(t *testing.T) {
+    s := mpserver.NewServer("")
+    s.Route("/", slowWriter("done", 100 * time.Millisecond))
+    h := httptest.NewServer(s)
+    defer h.Close()
+
+    res := make(chan string)
+    go func () {
+        resp, err := http.Get(h.URL)
+        if (err != nil) {
+            res <- err.Error()
+            return
+        }
+        defer resp.Body.Close()
+        body, _ := io.ReadAll(resp.Body)
+        res <- string(body)
+    }()
+    time.Sleep(20 * time.Millisecond)
+    shutdown(t, s)
+    if body := <- res; body != "done" {
+        t.Errorf("Response is %q, want done.", body)
+    }
+
+    // Requests are refused after shutdown.
+    rec := httptest.NewRecorder()
+    s.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+    if (rec.Code != http.StatusServiceUnavailable) {
+        t.Errorf("Response code is %d, want 503.", rec.Code)
+    }
+}
+
+func TestServerShutdownSharedInput(t *testing.T) {
+    s := mpserver.NewServer("")
+    in := mpserver.GetChan()
+    s.Listen("/a", in)
+    s.Listen("/b", in)
+    s.Start(mpserver.StringWriter, in)
+    shutdown(t, s)
+}
+
+func TestServerShutdownClosesWebSockets(t *testing.T) {
+    s := mpserver.NewServer("")
+    in := mpserver.GetChan()
+    s.WebSocketListen("/ws", in)
+    s.Start(mpserver.WebSocketWriter, in)
+    h := httptest.NewServer(s)
+    defer h.Close()
+
+    url := "ws" + strings.TrimPrefix(h.URL, "http") + "/ws"
+    conn, err := websocket.Dial(url, "", h.URL)
+    if (err != nil) {
+        t.Fatal(err)
+    }
+    defer conn.Close()
+    time.Sleep(20 * time.Millisecond)
+    shutdown(t, s)
+
+    var msg string
+    if err := websocket.Message.Receive(conn, &msg); err == nil {
+        t.Errorf("Connection is still open, received %q.", msg)
+    }
+}
+
+func TestServerLogger(t *testing.T) {
+    logger := &logRecorder{}
+    s := mpserver.NewServer("")
+    s.SetLogger(logger)
+    // StringWriter fails on jobs without a string result.
+    s.Route("/error", mpserver.StringWriter)
+    lost := mpserver.GetChan()
+    s.Listen("/lost", lost, mpserver.WithDeadline(10*time.Millisecond))
+    go func () {
+        for range lost {}
+    }()
+
+    for _, url := range []string{"/error", "/lost"} {
+        rec := httptest.NewRecorder()
+        s.ServeHTTP(rec, httptest.NewRequest("GET", url, nil))
+        if (rec.Code != http.StatusInternalServerError) {
+            t.Errorf("Response code of %s is %d, want 500.",
+                     url, rec.Code)
+        }
+    }
+    shutdown(t, s)
+
+    if (len(logger.find("request failed")) != 1) {
+        t.Error("Failed request wasn't logged by the server logger.")
+    }
+    entries := logger.find("pipeline lost the job")
+    if (len(entries) != 1 || entries[0].fields["pipeline"] != "/lost") {
+        t.Errorf("Lost job logs are %v, want one for /lost.", entries)
+    }
+}
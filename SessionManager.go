@@ -58,8 +58,8 @@ type State interface {
 // object and the result of the call to the Result function on
 // the current state is stored in the result field of the job,
 // before it is sent to the out channel.
-func startNewSession(job Job, initial State, 
-    seshExp time.Duration, storage Storage, out chan<- Job) {
+func startNewSession(job Job, initial State, seshExp time.Duration,
+    storage Storage, clock Clock, out chan<- Job) {
     id, err := GenerateRandomString(32)
     if (err != nil) {
         // The random generator failed.
@@ -77,7 +77,7 @@ func startNewSession(job Job, initial State,
     }
 
     // Store the mapping from the id to the current state.
-    storage.Set(id, StorageValue{state, time.Now().Add(seshExp)})
+    storage.Set(id, StorageValue{state, clock.Now().Add(seshExp)})
     job.SetResult(state.Result())
     job.SetHeader("Session-Id", id)
     out <- job
@@ -87,14 +87,29 @@ func startNewSession(job Job, initial State,
 // management.
 func SessionManager(storage Storage, initial State, 
                     seshExp time.Duration) Component {
+    return SessionManagerWithClock(
+        storage, initial, seshExp, SystemClock)
+}
+
+// SessionManagerWithClock returns a SessionManager that measures
+// the expiration of sessions by the provided clock.
+func SessionManagerWithClock(storage Storage, initial State, 
+        seshExp time.Duration, clock Clock) Component {
     noExpiration := seshExp <= 0
     return func (in <-chan Job, out chan<- Job) {
         for job := range in {
+            // Don't advance the session if the client has gone
+            // away.
+            if (skipCancelled(job)) {
+                out <- job
+                continue
+            }
+
             id := job.GetRequest().Header.Get("Session-Id")
             if (id == ""){
                 // No Session-Id was provided.
                 startNewSession(
-                    job, initial, seshExp, storage, out)
+                    job, initial, seshExp, storage, clock, out)
                 continue
             }
             storageValue, in := storage.Get(id)
@@ -103,11 +118,11 @@ func SessionManager(storage Storage, initial State,
                 // either invalid or it expired and was removed 
                 // from the storage.
                 startNewSession(
-                    job, initial, seshExp, storage, out)
+                    job, initial, seshExp, storage, clock, out)
                 continue
             }
 
-            now := time.Now()
+            now := clock.Now()
             if (noExpiration || storageValue.Time.After(now)) {
                 // Session hasn't expired yet or sessions don't 
                 // expire.
@@ -126,7 +141,7 @@ func SessionManager(storage Storage, initial State,
                 } else {
                     // Update the state in the storage, as 
                     // current state is not terminal.
-                    storageValue.Time = time.Now().Add(seshExp)
+                    storageValue.Time = clock.Now().Add(seshExp)
                     storageValue.Value = next
                     storage.Set(id, storageValue)
                     job.SetHeader("Session-Id", id)
@@ -139,7 +154,7 @@ func SessionManager(storage Storage, initial State,
                 // for this user.
                 storage.Remove(id)
                 startNewSession(
-                    job, initial, seshExp, storage, out)
+                    job, initial, seshExp, storage, clock, out)
             }
         }
         close(out)
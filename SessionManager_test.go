@@ -0,0 +1,74 @@
+package mpserver_test
+
+import (
+    "net/http"
+    "strconv"
+    "testing"
+    "time"
+    "mpserver"
+    "mpserver/mpservertest"
+)
+
+// visits is a session State that counts the requests made in the
+// session.
+type visits int
+
+func (v visits) Next(job mpserver.Job) (mpserver.State, error) {
+    return v + 1, nil
+}
+
+func (v visits) Terminal() bool {
+    return false
+}
+
+func (v visits) Result() interface{} {
+    return strconv.Itoa(int(v))
+}
+
+func TestSessionManagerExpiration(t *testing.T) {
+    clock := mpservertest.NewFakeClock(time.Now())
+    c := mpserver.SessionManagerWithClock(
+        mpserver.NewMemStorage(), visits(0), time.Minute, clock)
+    in := mpserver.GetChan()
+    out := mpserver.GetChan()
+    go c(in, out)
+    defer close(in)
+
+    // visit makes a request in the session with the provided id 
+    // and returns the response.
+    visit := func (id string) (string, string) {
+        job, rec := mpservertest.NewRequestJob("GET", "/", nil)
+        if (id != "") {
+            job.GetRequest().Header.Set("Session-Id", id)
+        }
+        in <- job
+        if err := mpservertest.RunWriter(
+                mpserver.StringWriter, <- out); err != nil {
+            t.Fatal(err)
+        }
+        if (rec.Code != http.StatusOK) {
+            t.Fatalf("Response code is %d.", rec.Code)
+        }
+        return rec.Body.String(), rec.Header().Get("Session-Id")
+    }
+
+    count, id := visit("")
+    if (count != "1" || id == "") {
+        t.Fatalf("First visit is %q in session %q.", count, id)
+    }
+    clock.Advance(time.Minute - time.Second)
+    if count, _ := visit(id); count != "2" {
+        t.Errorf("Visit before expiration is %q, want 2.", count)
+    }
+    // Every visit extends the session.
+    clock.Advance(time.Minute - time.Second)
+    if count, _ := visit(id); count != "3" {
+        t.Errorf("Visit before expiration is %q, want 3.", count)
+    }
+    clock.Advance(time.Minute)
+    count, newID := visit(id)
+    if (count != "1" || newID == id) {
+        t.Errorf("Visit after expiration is %q in session %q.", 
+            count, newID)
+    }
+}
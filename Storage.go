@@ -87,16 +87,23 @@ func NewMemStorage() Storage {
 // message on the shutdown channel.
 func StorageCleaner(storage Storage, shutDown <-chan bool, 
 					sleepTime time.Duration) {
+	StorageCleanerWithClock(storage, shutDown, sleepTime, SystemClock)
+}
+
+// StorageCleanerWithClock is a StorageCleaner that sleeps and 
+// checks the expiration of values using the provided clock.
+func StorageCleanerWithClock(storage Storage, shutDown <-chan bool,
+		sleepTime time.Duration, clock Clock) {
 	done := false
 	for !done {
-		time.Sleep(sleepTime)
+		clock.Sleep(sleepTime)
 		select {
 			case <-shutDown: { done = true; continue }
 			default: {}
 		}
 		for _, key := range storage.Keys() {
 			storageValue, _ := storage.Get(key)
-			if (storageValue.Time.Before(time.Now())) {
+			if (storageValue.Time.Before(clock.Now())) {
 				storage.CompareAndRemove(key, storageValue)
 			}
 		}
@@ -0,0 +1,43 @@
+package mpserver_test
+
+import (
+    "testing"
+    "time"
+    "mpserver"
+    "mpserver/mpservertest"
+)
+
+func TestStorageCleaner(t *testing.T) {
+    clock := mpservertest.NewFakeClock(time.Now())
+    storage := mpserver.NewMemStorage()
+    storage.Set("short", mpserver.StorageValue{
+        Value: 1, Time: clock.Now().Add(time.Second)})
+    storage.Set("long", mpserver.StorageValue{
+        Value: 2, Time: clock.Now().Add(time.Hour)})
+    shutDown := make(chan bool, 1)
+    done := make(chan bool)
+    go func () {
+        mpserver.StorageCleanerWithClock(
+            storage, shutDown, time.Minute, clock)
+        close(done)
+    }()
+
+    if (!clock.BlockUntil(1, time.Second)) {
+        t.Fatal("Cleaner isn't sleeping.")
+    }
+    clock.Advance(time.Minute)
+    eventually(t, "Expired value wasn't removed.", func () bool {
+        _, ok := storage.Get("short")
+        return !ok
+    })
+    if _, ok := storage.Get("long"); !ok {
+        t.Error("Value that hasn't expired was removed.")
+    }
+
+    shutDown <- true
+    if (!clock.BlockUntil(1, time.Second)) {
+        t.Fatal("Cleaner isn't sleeping.")
+    }
+    clock.Advance(time.Minute)
+    <- done
+}
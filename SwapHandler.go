@@ -0,0 +1,115 @@
+package mpserver
+
+import (
+    "net/http"
+    "sync"
+    "sync/atomic"
+)
+
+// swapTarget is a pipeline, that is an input channel and a 
+// running Writer, that a SwapHandler sends jobs to.
+type swapTarget struct {
+    in chan Job
+    writerDone chan bool
+
+    // Handlers hold the read lock while sending to the input 
+    // channel, so that it is closed only after all sends finish.
+    lock sync.RWMutex
+    closed bool
+}
+
+// startTarget creates an input channel and starts the writer on
+// it.
+func startTarget(writer Writer) *swapTarget {
+    target := &swapTarget{in: GetChan(), writerDone: make(chan bool)}
+    go func () {
+        writer(target.in)
+        close(target.writerDone)
+    }()
+    return target
+}
+
+// drain stops sending jobs to the target, closes its input 
+// channel and waits until the writer terminates, that is until
+// all jobs that were sent to it are written.
+func (target *swapTarget) drain() {
+    target.lock.Lock()
+    target.closed = true
+    target.lock.Unlock()
+    close(target.in)
+    <- target.writerDone
+}
+
+// SwapHandler is an http.Handler that for each incoming request
+// creates a Job object and sends it to a pipeline that can be 
+// replaced at runtime. This allows changing the configuration of 
+// the pipeline, such as cache expirations or numbers of workers,
+// without restarting the process or dropping requests.
+type SwapHandler struct {
+    config *handlerConfig
+    current atomic.Pointer[swapTarget]
+    swapLock sync.Mutex
+}
+
+// NewSwapHandler returns a SwapHandler that sends jobs to a 
+// pipeline that consists of the provided writer.
+func NewSwapHandler(writer Writer, opts ...HandlerOption) *SwapHandler {
+    h := &SwapHandler{config: newHandlerConfig(opts)}
+    h.current.Store(startTarget(writer))
+    return h
+}
+
+// ServeHTTP sends a job for the request to the current pipeline
+// and waits for it to be written. After Close, requests are
+// rejected with the 503 response code.
+func (h *SwapHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    h.config.serve(w, r, h.send)
+}
+
+// send sends the job to the current pipeline. If the pipeline 
+// is replaced while the job is being sent, it is sent to the new
+// pipeline instead.
+func (h *SwapHandler) send(job *jobStruct) bool {
+    for {
+        target := h.current.Load()
+        if (target == nil) {
+            http.Error(job.responseWriter, "Pipeline is closed.",
+                http.StatusServiceUnavailable)
+            return false
+        }
+
+        target.lock.RLock()
+        if (target.closed) {
+            // The target was swapped out, so try again.
+            target.lock.RUnlock()
+            continue
+        }
+        sent := h.config.send(job, target.in)
+        target.lock.RUnlock()
+        return sent
+    }
+}
+
+// Swap atomically replaces the pipeline with a new one that 
+// consists of the provided writer. New jobs are sent to the new 
+// pipeline, while the old one is drained by closing its input 
+// channel. Swap returns after the old pipeline terminated.
+func (h *SwapHandler) Swap(writer Writer) {
+    h.swapLock.Lock()
+    defer h.swapLock.Unlock()
+    old := h.current.Swap(startTarget(writer))
+    if (old != nil) {
+        old.drain()
+    }
+}
+
+// Close drains the current pipeline and returns after it 
+// terminated. Requests that arrive after Close are rejected.
+func (h *SwapHandler) Close() {
+    h.swapLock.Lock()
+    defer h.swapLock.Unlock()
+    old := h.current.Swap(nil)
+    if (old != nil) {
+        old.drain()
+    }
+}
@@ -0,0 +1,41 @@
+package mpserver_test
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+    "mpserver"
+)
+
+func TestSwapHandler(t *testing.T) {
+    get := func (h http.Handler) *httptest.ResponseRecorder {
+        rec := httptest.NewRecorder()
+        h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+        return rec
+    }
+    h := mpserver.NewSwapHandler(slowWriter("a", time.Millisecond))
+
+    // Requests in flight during a swap are answered by one of 
+    // the pipelines.
+    done := make(chan string)
+    for i := 0; i < 20; i++ {
+        go func () {
+            done <- get(h).Body.String()
+        }()
+    }
+    h.Swap(slowWriter("b", time.Millisecond))
+    for i := 0; i < 20; i++ {
+        if body := <- done; body != "a" && body != "b" {
+            t.Errorf("Response is %q.", body)
+        }
+    }
+    if body := get(h).Body.String(); body != "b" {
+        t.Errorf("Response after swap is %q, want b.", body)
+    }
+
+    h.Close()
+    if code := get(h).Code; code != http.StatusServiceUnavailable {
+        t.Errorf("Response code after close is %d, want 503.", code)
+    }
+}
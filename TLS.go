@@ -0,0 +1,239 @@
+package mpserver
+
+import (
+    "crypto/tls"
+    "errors"
+    "net/http"
+    "os"
+    "sync"
+    "time"
+)
+
+// DefaultReloadInterval is the interval in which certificate 
+// files are checked for changes, if TLSConfig doesn't specify 
+// one.
+const DefaultReloadInterval = 10 * time.Second
+
+// CertFiles contains paths to a certificate and its private key 
+// in PEM format.
+type CertFiles struct {
+    CertFile string
+    KeyFile string
+}
+
+// TLSConfig specifies how a server serves HTTPS.
+type TLSConfig struct {
+    // Certificates are the certificates served by the server. 
+    // The certificate is selected based on the server name
+    // requested by the client (SNI). The first certificate is 
+    // used, when none of them matches.
+    Certificates []CertFiles
+
+    // ReloadInterval is the interval in which the certificate 
+    // files are checked for changes. DefaultReloadInterval is 
+    // used if it is zero and reloading is disabled if it is 
+    // negative.
+    ReloadInterval time.Duration
+
+    // HTTP2 enables HTTP/2 for clients that support it.
+    HTTP2 bool
+}
+
+// CertReloader holds a set of certificates and reloads them from
+// their files when the files change, so that certificates can be
+// renewed without restarting the server. It is safe for 
+// concurrent use.
+type CertReloader struct {
+    files []CertFiles
+
+    lock sync.RWMutex
+    certs []*tls.Certificate
+    modTimes []time.Time
+
+    stop chan bool
+    stopOnce sync.Once
+    logger Logger // Logs failed reloads, DefaultLogger if nil.
+}
+
+// NewCertReloader loads the provided certificates and returns a 
+// CertReloader that checks the files for changes in the provided 
+// interval. If the interval isn't positive, the certificates are
+// only reloaded by calling the Reload method.
+func NewCertReloader(files []CertFiles, 
+                     interval time.Duration) (*CertReloader, error) {
+    return newCertReloader(files, interval, nil)
+}
+
+// newCertReloader returns a CertReloader that logs failed reloads
+// using the provided logger, or DefaultLogger if it is nil.
+func newCertReloader(files []CertFiles, interval time.Duration, 
+                     logger Logger) (*CertReloader, error) {
+    if (len(files) == 0) {
+        return nil, errors.New("No certificates provided.")
+    }
+    cr := &CertReloader{
+        files: files,
+        certs: make([]*tls.Certificate, len(files)),
+        modTimes: make([]time.Time, len(files)),
+        stop: make(chan bool),
+        logger: logger,
+    }
+    if err := cr.Reload(); err != nil {
+        return nil, err
+    }
+    if (interval > 0) {
+        go cr.watch(interval)
+    }
+    return cr, nil
+}
+
+// modTime returns the latest modification time of the files.
+func (files CertFiles) modTime() (time.Time, error) {
+    var latest time.Time
+    for _, path := range []string{files.CertFile, files.KeyFile} {
+        info, err := os.Stat(path)
+        if (err != nil) {
+            return latest, err
+        }
+        if (info.ModTime().After(latest)) {
+            latest = info.ModTime()
+        }
+    }
+    return latest, nil
+}
+
+// Reload loads the certificates whose files changed since they 
+// were last loaded. If a certificate can't be loaded, the 
+// previous version is kept and the error is returned.
+func (cr *CertReloader) Reload() error {
+    var firstErr error
+    for i, files := range cr.files {
+        modTime, err := files.modTime()
+        if (err == nil) {
+            cr.lock.RLock()
+            changed := cr.certs[i] == nil || 
+                !modTime.Equal(cr.modTimes[i])
+            cr.lock.RUnlock()
+            if (!changed) {
+                continue
+            }
+
+            var cert tls.Certificate
+            cert, err = tls.LoadX509KeyPair(
+                files.CertFile, files.KeyFile)
+            if (err == nil) {
+                cr.lock.Lock()
+                cr.certs[i] = &cert
+                cr.modTimes[i] = modTime
+                cr.lock.Unlock()
+            }
+        }
+        if (err != nil && firstErr == nil) {
+            firstErr = err
+        }
+    }
+    return firstErr
+}
+
+// watch reloads the certificates in the provided interval until
+// the reloader is closed.
+func (cr *CertReloader) watch(interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    for {
+        select {
+            case <- ticker.C: {
+                if err := cr.Reload(); err != nil {
+                    logger := cr.logger
+                    if (logger == nil) {
+                        logger = DefaultLogger()
+                    }
+                    logger.Error("reloading certificate failed",
+                        "error", err.Error())
+                }
+            }
+            case <- cr.stop: return
+        }
+    }
+}
+
+// Close stops checking the certificate files for changes.
+func (cr *CertReloader) Close() {
+    cr.stopOnce.Do(func () {
+        close(cr.stop)
+    })
+}
+
+// GetCertificate returns the certificate for the server name 
+// requested by the client. It can be used as the GetCertificate
+// field of tls.Config.
+func (cr *CertReloader) GetCertificate(
+    hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+    cr.lock.RLock()
+    defer cr.lock.RUnlock()
+    for _, cert := range cr.certs {
+        if (cert != nil && hello.SupportsCertificate(cert) == nil) {
+            return cert, nil
+        }
+    }
+    for _, cert := range cr.certs {
+        if (cert != nil) {
+            return cert, nil
+        }
+    }
+    return nil, errors.New("No certificate loaded.")
+}
+
+// configure sets up the provided http.Server to serve HTTPS 
+// according to the config, but doesn't start serving. The 
+// returned CertReloader should be closed when the server is shut
+// down. Failed reloads are logged using the provided logger.
+func (config TLSConfig) configure(server *http.Server, 
+                                  logger Logger) (*CertReloader, error) {
+    interval := config.ReloadInterval
+    if (interval == 0) {
+        interval = DefaultReloadInterval
+    }
+    cr, err := newCertReloader(config.Certificates, interval, logger)
+    if (err != nil) {
+        return nil, err
+    }
+
+    server.TLSConfig = &tls.Config{
+        MinVersion: tls.VersionTLS12,
+        GetCertificate: cr.GetCertificate,
+    }
+    if (!config.HTTP2) {
+        // A non-nil map disables HTTP/2.
+        server.TLSNextProto = make(map[string]func(
+            *http.Server, *tls.Conn, http.Handler))
+    }
+    return cr, nil
+}
+
+// ListenAndServeTLS behaves like ListenAndServe, but serves 
+// HTTPS using the provided certificate and key files. The files
+// are reloaded when they change.
+func ListenAndServeTLS(addr, certFile, keyFile string, 
+                       handler http.Handler) error {
+    return ListenAndServeTLSConfig(addr, handler, ServerConfig{},
+        TLSConfig{Certificates: []CertFiles{{certFile, keyFile}}})
+}
+
+// ListenAndServeTLSConfig behaves like ListenAndServeConfig, but
+// serves HTTPS according to the provided TLSConfig.
+func ListenAndServeTLSConfig(addr string, handler http.Handler, 
+        config ServerConfig, tlsConfig TLSConfig) error {
+    if (handler == nil) {
+        handler = DefaultServeMux
+    }
+    server := config.httpServer(addr, handler)
+    cr, err := tlsConfig.configure(server, nil)
+    if (err != nil) {
+        return err
+    }
+    defer cr.Close()
+
+    DefaultLogger().Info("listening", "addr", addr, "tls", true)
+    return server.ListenAndServeTLS("", "")
+}
@@ -0,0 +1,191 @@
+package mpserver_test
+
+import (
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/rand"
+    "crypto/tls"
+    "crypto/x509"
+    "encoding/pem"
+    "math/big"
+    "net"
+    "net/http"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+    "mpserver"
+)
+
+// writeCert writes a self-signed certificate for the provided
+// host name and its key to the directory, replacing the previous
+// ones, and returns their paths.
+func writeCert(t *testing.T, dir, host string) mpserver.CertFiles {
+    t.Helper()
+    key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    if (err != nil) {
+        t.Fatal(err)
+    }
+    template := &x509.Certificate{
+        SerialNumber: big.NewInt(time.Now().UnixNano()),
+        DNSNames: []string{host},
+        NotBefore: time.Now().Add(-time.Hour),
+        NotAfter: time.Now().Add(time.Hour),
+        KeyUsage: x509.KeyUsageDigitalSignature,
+        ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+    }
+    der, err := x509.CreateCertificate(rand.Reader, template,
+        template, &key.PublicKey, key)
+    if (err != nil) {
+        t.Fatal(err)
+    }
+    keyDer, err := x509.MarshalECPrivateKey(key)
+    if (err != nil) {
+        t.Fatal(err)
+    }
+
+    files := mpserver.CertFiles{
+        CertFile: filepath.Join(dir, "cert.pem"),
+        KeyFile: filepath.Join(dir, "key.pem"),
+    }
+    certPem := pem.EncodeToMemory(
+        &pem.Block{Type: "CERTIFICATE", Bytes: der})
+    keyPem := pem.EncodeToMemory(
+        &pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+    if err := os.WriteFile(files.CertFile, certPem, 0600); err != nil {
+        t.Fatal(err)
+    }
+    if err := os.WriteFile(files.KeyFile, keyPem, 0600); err != nil {
+        t.Fatal(err)
+    }
+    return files
+}
+
+// servedName performs a TLS handshake with the reloader for the
+// provided server name and returns the host name of the
+// certificate that was served.
+func servedName(t *testing.T, cr *mpserver.CertReloader,
+                serverName string) string {
+    t.Helper()
+    serverConn, clientConn := net.Pipe()
+    defer clientConn.Close()
+    server := tls.Server(serverConn,
+        &tls.Config{GetCertificate: cr.GetCertificate})
+    go func () {
+        server.Handshake()
+        server.Close()
+    }()
+    client := tls.Client(clientConn, &tls.Config{
+        ServerName: serverName, InsecureSkipVerify: true})
+    if err := client.Handshake(); err != nil {
+        t.Fatal(err)
+    }
+    return client.ConnectionState().PeerCertificates[0].DNSNames[0]
+}
+
+func TestCertReloaderSNI(t *testing.T) {
+    files := []mpserver.CertFiles{
+        writeCert(t, t.TempDir(), "a.test"),
+        writeCert(t, t.TempDir(), "b.test"),
+    }
+    cr, err := mpserver.NewCertReloader(files, 0)
+    if (err != nil) {
+        t.Fatal(err)
+    }
+    defer cr.Close()
+
+    tests := []struct {
+        serverName string
+        want string
+    }{
+        {"a.test", "a.test"},
+        {"b.test", "b.test"},
+        // The first certificate is the fallback.
+        {"c.test", "a.test"},
+    }
+    for _, test := range tests {
+        if got := servedName(t, cr, test.serverName); got != test.want {
+            t.Errorf("Served %s for %s, want %s.",
+                     got, test.serverName, test.want)
+        }
+    }
+}
+
+func TestCertReloaderReload(t *testing.T) {
+    dir := t.TempDir()
+    files := writeCert(t, dir, "old.test")
+    cr, err := mpserver.NewCertReloader(
+        []mpserver.CertFiles{files}, 0)
+    if (err != nil) {
+        t.Fatal(err)
+    }
+    defer cr.Close()
+    if got := servedName(t, cr, "old.test"); got != "old.test" {
+        t.Fatalf("Served %s, want old.test.", got)
+    }
+
+    // A broken certificate is reported and the old one is kept.
+    err = os.WriteFile(files.CertFile, []byte("broken"), 0600)
+    if (err != nil) {
+        t.Fatal(err)
+    }
+    later := time.Now().Add(time.Minute)
+    os.Chtimes(files.CertFile, later, later)
+    if err := cr.Reload(); err == nil {
+        t.Error("Reloading a broken certificate didn't fail.")
+    }
+    if got := servedName(t, cr, "old.test"); got != "old.test" {
+        t.Errorf("Served %s after a failed reload, want old.test.", got)
+    }
+
+    writeCert(t, dir, "new.test")
+    later = later.Add(time.Minute)
+    os.Chtimes(files.CertFile, later, later)
+    if err := cr.Reload(); err != nil {
+        t.Fatal(err)
+    }
+    if got := servedName(t, cr, "new.test"); got != "new.test" {
+        t.Errorf("Served %s after reload, want new.test.", got)
+    }
+}
+
+func TestServerServeTLSTwice(t *testing.T) {
+    config := mpserver.TLSConfig{
+        Certificates: []mpserver.CertFiles{
+            writeCert(t, t.TempDir(), "a.test")},
+    }
+    s := mpserver.NewServer("")
+    s.Route("/", mpserver.StringWriter)
+    l, err := net.Listen("tcp", "127.0.0.1:0")
+    if (err != nil) {
+        t.Fatal(err)
+    }
+    s.AddListener(l)
+    res := make(chan error, 1)
+    go func () {
+        res <- s.ServeTLS(config)
+    }()
+
+    client := &http.Client{Transport: &http.Transport{
+        TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+    eventually(t, "Server didn't start serving HTTPS.", func () bool {
+        resp, err := client.Get("https://" + l.Addr().String())
+        if (err != nil) {
+            return false
+        }
+        resp.Body.Close()
+        return true
+    })
+    if err := s.ServeTLS(config); err == nil ||
+        err == http.ErrServerClosed {
+        t.Errorf("Second ServeTLS returned %v, want an error.", err)
+    }
+
+    shutdown(t, s)
+    if err := <- res; err != http.ErrServerClosed {
+        t.Errorf("ServeTLS returned %v, want ErrServerClosed.", err)
+    }
+    if err := s.ServeTLS(config); err != http.ErrServerClosed {
+        t.Errorf("ServeTLS after Shutdown returned %v.", err)
+    }
+}
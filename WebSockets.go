@@ -0,0 +1,177 @@
+package mpserver
+
+import (
+    "errors"
+    "net/http"
+    "golang.org/x/net/websocket"
+)
+
+// WebSocketEvent is a type of an event on a WebSocket connection
+// that a job represents.
+type WebSocketEvent int
+
+const (
+    // WebSocketOpen is the event of a client opening a new 
+    // connection. The result field of the job is nil.
+    WebSocketOpen WebSocketEvent = iota
+    // WebSocketMessage is the event of a client sending a 
+    // message. The result field of the job contains the message
+    // as a string.
+    WebSocketMessage
+    // WebSocketClose is the event of a connection being closed.
+    // The result field of the job is nil.
+    WebSocketClose
+)
+
+func (event WebSocketEvent) String() string {
+    switch event {
+        case WebSocketOpen: return "open"
+        case WebSocketMessage: return "message"
+        case WebSocketClose: return "close"
+    }
+    return "unknown"
+}
+
+var webSocketEventKey = NewKey[WebSocketEvent]("WebSocketEvent")
+
+// GetWebSocketEvent returns the WebSocket event that the provided
+// job represents and a boolean that indicates whether the job 
+// comes from a WebSocket connection.
+func GetWebSocketEvent(job Job) (WebSocketEvent, bool) {
+    return webSocketEventKey.Get(job)
+}
+
+// webSocketResponseWriter is an http.ResponseWriter that sends 
+// everything that is written to it as a text message on a 
+// WebSocket connection. This way the ordinary Writers can be 
+// used to respond to jobs that come from WebSockets. Headers 
+// and response codes are ignored.
+type webSocketResponseWriter struct {
+    conn *websocket.Conn
+    header http.Header
+}
+
+func (w *webSocketResponseWriter) Header() http.Header {
+    return w.header
+}
+
+func (w *webSocketResponseWriter) WriteHeader(int) {}
+
+func (w *webSocketResponseWriter) Write(body []byte) (int, error) {
+    if err := websocket.Message.Send(w.conn, string(body)); err != nil {
+        return 0, err
+    }
+    return len(body), nil
+}
+
+// sendWebSocketJob creates a job for the provided event and 
+// message, sends it to the output channel and waits until it is
+// written. It returns false if the job couldn't be sent, because
+// the connection went away.
+func sendWebSocketJob(conn *websocket.Conn, out chan<- Job,
+                      event WebSocketEvent, msg interface{}) bool {
+    job := newJob(&webSocketResponseWriter{conn, make(http.Header)}, 
+        conn.Request())
+    job.webSocket = conn
+    job.SetResult(msg)
+    webSocketEventKey.Set(job, event)
+
+    select {
+        case out <- job: {}
+        case <- job.Context().Done(): return false
+    }
+    <- job.done
+    return true
+}
+
+// WebSocketHandler returns an http.Handler object that accepts
+// WebSocket connections and sends a Job object to the output 
+// channel for every event on these connections. That is when the
+// connection is opened, for every message that the client sends 
+// and when the connection is closed. The messages of a single 
+// connection are processed one at a time, so the next message 
+// is read only after the job for the previous one was written.
+func WebSocketHandler(out chan<- Job) http.Handler {
+    return webSocketHandler(out, nil)
+}
+
+// webSocketHandler returns a WebSocketHandler. If track isn't 
+// nil, it is called for every accepted connection. The connection
+// is refused if track returns false, otherwise the returned 
+// function is called when the connection is finished.
+func webSocketHandler(out chan<- Job, 
+        track func (*websocket.Conn) (func (), bool)) http.Handler {
+    return websocket.Handler(func (conn *websocket.Conn) {
+        if (track != nil) {
+            untrack, ok := track(conn)
+            if (!ok) {
+                return
+            }
+            defer untrack()
+        }
+        if (!sendWebSocketJob(conn, out, WebSocketOpen, nil)) {
+            return
+        }
+        for {
+            var msg string
+            if err := websocket.Message.Receive(conn, &msg); err != nil {
+                break
+            }
+            if (!sendWebSocketJob(conn, out, WebSocketMessage, msg)) {
+                return
+            }
+        }
+        sendWebSocketJob(conn, out, WebSocketClose, nil)
+    })
+}
+
+// WebSocketListen registers a WebSocket handler on the provided 
+// ServeMux for the provided url, that will for each event on the
+// accepted connections create a Job object and send it to the 
+// output channel. If the provided ServeMux is nil 
+// DefaultServeMux is used.
+func WebSocketListen(url string, out chan<- Job, mux *http.ServeMux) {
+    if (mux != nil) {
+        mux.Handle(url, WebSocketHandler(out))
+    } else {
+        DefaultServeMux.Handle(url, WebSocketHandler(out))
+    }
+}
+
+// WebSocketWriter is a writer that sends the result field of 
+// input jobs back on the WebSocket connection, that the jobs 
+// came from. Strings are sent as text messages, byte slices as 
+// binary messages, errors as text messages with the error 
+// message and all other objects are sent as JSON. Nothing is 
+// sent for jobs with nil result, such as the open and close 
+// events.
+func WebSocketWriter(in <-chan Job) {
+    for job := range in {
+        if (closeCancelled(job)) {
+            continue
+        }
+        conn := job.getWebSocket()
+        if (conn == nil) {
+            writeError(job, errors.New(
+                "WebSocketWriter provided with a job that " + 
+                "doesn't come from a WebSocket."))
+            continue
+        }
+
+        var err error
+        switch res := job.GetResult().(type) {
+            case nil: {}
+            case string: err = websocket.Message.Send(conn, res)
+            case []byte: err = websocket.Message.Send(conn, res)
+            case error: err = websocket.Message.Send(conn, res.Error())
+            default: err = websocket.JSON.Send(conn, res)
+        }
+        if (err != nil) {
+            job.Logger().Warn("sending WebSocket message failed",
+                "request_id", job.RequestID(), 
+                "component", "WebSocketWriter", 
+                "error", err.Error())
+        }
+        job.close()
+    }
+}
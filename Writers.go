@@ -2,7 +2,6 @@ package mpserver
 
 import (
     "fmt"
-	"log"
     "errors"
     "net/http"
     "encoding/json"
@@ -17,7 +16,9 @@ import (
 // the client that is represented by the provided job.
 func writeError(job Job, err error) {
     job.SetResponseCodeIfUndef(http.StatusInternalServerError)
-    log.Println("Error:", err.Error())
+    job.Logger().Error("request failed", 
+        "request_id", job.RequestID(), 
+        "status", job.getResponseCode(), "error", err.Error())
     http.Error(job.getResponseWriter(), 
         err.Error(), job.getResponseCode())
     job.close()
@@ -30,6 +31,55 @@ func writeWrongInput(job Job, template string) {
     writeError(job, err)
 }
 
+// SplitErrors returns a writer that passes its input jobs through
+// the linked components and then sends the jobs with an error in
+// the result field to errWriter and all other jobs to writer.
+// The returned writer terminates after both writers terminate.
+func SplitErrors(components []Component, 
+                 writer, errWriter Writer) Writer {
+    return func (in <-chan Job) {
+        src := in
+        if (len(components) > 0) {
+            out := GetChan()
+            go LinkComponents(components...)(in, out)
+            src = out
+        }
+        toWriter := GetChan()
+        toErrWriter := GetChan()
+        go ErrorRouter(src, toWriter, toErrWriter)
+
+        errDone := make(chan bool)
+        go func () {
+            errWriter(toErrWriter)
+            close(errDone)
+        }()
+        writer(toWriter)
+        <- errDone
+    }
+}
+
+// closeCancelled completes the provided job without writing 
+// anything if its client has gone away. Open files and response
+// bodies in the result field are closed. It returns true if 
+// that is the case.
+func closeCancelled(job Job) bool {
+    if (isCancelled(job)) {
+        closeResult(job.GetResult())
+        job.close()
+        return true
+    }
+    return false
+}
+
+// closeResult closes the provided result if it is an open file or
+// a response with a body.
+func closeResult(result interface{}) {
+    switch res := result.(type) {
+        case io.Closer: res.Close()
+        case *http.Response: res.Body.Close()
+    }
+}
+
 //-------------------- Output Writers ---------------------------
 
 // Writer is the end of the pipeline which writes results back to
@@ -46,6 +96,9 @@ type WriterFunc func (job Job) ([]byte, error)
 func MakeWriter(writerFunc WriterFunc) Writer {
     return func (in <-chan Job) {
         for job := range in {
+            if (closeCancelled(job)) {
+                continue
+            }
             resp, err := writerFunc(job)
 
             if (err == nil) {
@@ -66,6 +119,9 @@ func MakeWriter(writerFunc WriterFunc) Writer {
 func ErrorWriter(in <-chan Job) {
     errorTemplate := "Passed in %t to ErrorWriter."
     for job := range in {
+        if (closeCancelled(job)) {
+            continue
+        }
         err, ok := job.GetResult().(error)
         if (!ok) {
             job.SetResponseCode(http.StatusInternalServerError)
@@ -82,6 +138,9 @@ func ErrorWriter(in <-chan Job) {
 func StringWriter(in <-chan Job) {
     errorTemplate := "Passed in %t to StringWriter."
     for job := range in {
+        if (closeCancelled(job)) {
+            continue
+        }
         s, ok := job.GetResult().(string)
         if (!ok) {
             writeWrongInput(job, errorTemplate)
@@ -99,6 +158,9 @@ func StringWriter(in <-chan Job) {
 // can be converted to a JSON string using the json module.
 func JsonWriter(in <-chan Job) {
     for job := range in {
+        if (closeCancelled(job)) {
+            continue
+        }
         js, err := json.Marshal(job.GetResult())
         if err != nil {
             writeError(job, err)
@@ -118,6 +180,9 @@ func JsonWriter(in <-chan Job) {
 func GzipWriter(in <-chan Job) {
     errorTemplate := "Passed in %t to GzipWriter."
 	for job := range in {
+		if (closeCancelled(job)) {
+			continue
+		}
 		reader, ok := job.GetResult().(io.ReadCloser)
 		if (!ok) {
 			writeWrongInput(job, errorTemplate)
@@ -143,6 +208,9 @@ func GzipWriter(in <-chan Job) {
 func GenericWriter(in <-chan Job) {
     errorTemplate := "Passed in %t to GenericWriter."
 	for job := range in {
+		if (closeCancelled(job)) {
+			continue
+		}
 		reader, ok := job.GetResult().(io.ReadCloser)
 		if (!ok) {
 			writeWrongInput(job, errorTemplate)
@@ -162,6 +230,9 @@ func GenericWriter(in <-chan Job) {
 func ResponseWriter(in <-chan Job) {
     errorTemplate := "Passed in %t to ResponseWriter."
     for job := range in {
+        if (closeCancelled(job)) {
+            continue
+        }
         resp, ok := job.GetResult().(Response)
         if (!ok) {
             writeWrongInput(job, errorTemplate)
@@ -186,6 +257,9 @@ func ResponseWriter(in <-chan Job) {
 func HttpResponseWriter(in <-chan Job) {
     errorTemplate := "Passed in %t to HttpResponseWriter."
     for job := range in {
+        if (closeCancelled(job)) {
+            continue
+        }
         resp, ok := job.GetResult().(*http.Response)
         if (!ok) {
             writeWrongInput(job, errorTemplate)
@@ -196,6 +270,7 @@ func HttpResponseWriter(in <-chan Job) {
         for key, value := range resp.Header {
             header.Set(key, strings.Join(value, ""))
         }
+        job.SetResponseCodeIfUndef(resp.StatusCode)
         job.writeHeader()
 
         func() {
@@ -0,0 +1,169 @@
+package mpserver_test
+
+import (
+    "bytes"
+    "compress/gzip"
+    "errors"
+    "io"
+    "net/http"
+    "strings"
+    "sync/atomic"
+    "testing"
+    "time"
+    "mpserver"
+    "mpserver/mpservertest"
+)
+
+// write runs the writer with a job that has the provided result 
+// and returns the recorder of the job.
+func write(t *testing.T, w mpserver.Writer, 
+           result interface{}) *mpservertest.Recorder {
+    t.Helper()
+    job, rec := mpservertest.NewRequestJob("GET", "/", nil)
+    job.SetResult(result)
+    if err := mpservertest.RunWriter(w, job); err != nil {
+        t.Fatal(err)
+    }
+    if (!rec.Completed()) {
+        t.Fatal("Job wasn't completed.")
+    }
+    return rec
+}
+
+// expect fails the test if the recorded response doesn't have 
+// the provided code and body.
+func expect(t *testing.T, rec *mpservertest.Recorder, 
+            code int, body string) {
+    t.Helper()
+    if (rec.Code != code) {
+        t.Errorf("Response code is %d, want %d.", rec.Code, code)
+    }
+    if (rec.Body.String() != body) {
+        t.Errorf("Body is %q, want %q.", rec.Body.String(), body)
+    }
+}
+
+func TestStringWriter(t *testing.T) {
+    expect(t, write(t, mpserver.StringWriter, "hello"), 
+        http.StatusOK, "hello")
+}
+
+func TestJsonWriter(t *testing.T) {
+    rec := write(t, mpserver.JsonWriter, map[string]int{"a": 1})
+    expect(t, rec, http.StatusOK, `{"a":1}`)
+    if (rec.Header().Get("Content-Type") != "application/json") {
+        t.Errorf("Content type is %q.", rec.Header().Get("Content-Type"))
+    }
+}
+
+func TestErrorWriter(t *testing.T) {
+    job, rec := mpservertest.NewRequestJob("GET", "/", nil)
+    job.SetResult(errors.New("Not here."))
+    job.SetResponseCode(http.StatusNotFound)
+    if err := mpservertest.RunWriter(mpserver.ErrorWriter, job); err != nil {
+        t.Fatal(err)
+    }
+    expect(t, rec, http.StatusNotFound, "Not here.\n")
+}
+
+func TestGenericWriter(t *testing.T) {
+    body := io.NopCloser(strings.NewReader("body"))
+    expect(t, write(t, mpserver.GenericWriter, body), 
+        http.StatusOK, "body")
+}
+
+func TestGzipWriter(t *testing.T) {
+    body := io.NopCloser(strings.NewReader("body"))
+    rec := write(t, mpserver.GzipWriter, body)
+    if (rec.Header().Get("Content-Encoding") != "gzip") {
+        t.Fatalf("Content encoding is %q.", 
+            rec.Header().Get("Content-Encoding"))
+    }
+    reader, err := gzip.NewReader(rec.Body)
+    if (err != nil) {
+        t.Fatal(err)
+    }
+    data, err := io.ReadAll(reader)
+    if (err != nil || string(data) != "body") {
+        t.Errorf("Decompressed %q, %v.", data, err)
+    }
+}
+
+func TestResponseWriter(t *testing.T) {
+    resp := mpserver.Response{
+        Header: http.Header{"X-Test": {"yes"}},
+        ResponseCode: http.StatusAccepted,
+        Body: []byte("body"),
+    }
+    rec := write(t, mpserver.ResponseWriter, resp)
+    expect(t, rec, http.StatusAccepted, "body")
+    if (rec.Header().Get("X-Test") != "yes") {
+        t.Error("Header of the response wasn't copied.")
+    }
+}
+
+func TestHttpResponseWriter(t *testing.T) {
+    resp := &http.Response{
+        StatusCode: http.StatusOK,
+        Header: http.Header{"X-Test": {"yes"}},
+        Body: io.NopCloser(bytes.NewBufferString("body")),
+    }
+    rec := write(t, mpserver.HttpResponseWriter, resp)
+    expect(t, rec, http.StatusOK, "body")
+    if (rec.Header().Get("X-Test") != "yes") {
+        t.Error("Header of the response wasn't copied.")
+    }
+}
+
+func TestMakeWriter(t *testing.T) {
+    w := mpserver.MakeWriter(func (job mpserver.Job) ([]byte, error) {
+        if s, ok := job.GetResult().(string); ok {
+            return []byte(strings.ToUpper(s)), nil
+        }
+        return nil, errors.New("Not a string.")
+    })
+    expect(t, write(t, w, "abc"), http.StatusOK, "ABC")
+    expect(t, write(t, w, 1), 
+        http.StatusInternalServerError, "Not a string.\n")
+}
+
+func TestWritersWrongInput(t *testing.T) {
+    writers := map[string]mpserver.Writer{
+        "StringWriter": mpserver.StringWriter,
+        "ErrorWriter": mpserver.ErrorWriter,
+        "GenericWriter": mpserver.GenericWriter,
+        "GzipWriter": mpserver.GzipWriter,
+        "ResponseWriter": mpserver.ResponseWriter,
+        "HttpResponseWriter": mpserver.HttpResponseWriter,
+    }
+    for name, w := range writers {
+        rec := write(t, w, 42)
+        if (rec.Code != http.StatusInternalServerError) {
+            t.Errorf("%s: response code is %d, want 500.", 
+                name, rec.Code)
+        }
+    }
+}
+
+func TestSplitErrors(t *testing.T) {
+    var errWriterDone atomic.Bool
+    errWriter := func (in <-chan mpserver.Job) {
+        mpserver.ErrorWriter(in)
+        // Finishes after the other writer.
+        time.Sleep(10 * time.Millisecond)
+        errWriterDone.Store(true)
+    }
+    w := mpserver.SplitErrors(
+        []mpserver.Component{failOn("/fail")},
+        mpserver.StringWriter, errWriter)
+    good, goodRec := mpservertest.NewRequestJob("GET", "/", nil)
+    bad, badRec := mpservertest.NewRequestJob("GET", "/fail", nil)
+    if err := mpservertest.RunWriter(w, good, bad); err != nil {
+        t.Fatal(err)
+    }
+    expect(t, goodRec, http.StatusOK, "ok")
+    expect(t, badRec, http.StatusInternalServerError, "Failed.\n")
+    if (!errWriterDone.Load()) {
+        t.Error("Writer terminated before the error writer.")
+    }
+}
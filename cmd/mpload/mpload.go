@@ -0,0 +1,341 @@
+// Command mpload generates HTTP load at a constant rate and 
+// reports the latency of the responses. It replaces the httperf 
+// and ahpclient runs described in fileServerTest/testingNotes.txt.
+//
+// The load is open-loop: requests are sent at the times given by
+// the rate, regardless of how long the previous requests take. 
+// The latency of a request is measured from the time it was 
+// supposed to be sent, so a server that stalls is not hidden by a
+// load generator that waits for it (coordinated omission). For the
+// same reason the latency statistics cover all requests, not only
+// the successful ones. A request that timed out counts with at 
+// least the timeout.
+//
+// Usage:
+//
+//     mpload -url http://localhost:8080/go/testFile.txt,http://localhost:8080/mpserver/testFile.txt \
+//         -rate 100,200,300 -duration 30s -format csv -out results.csv
+//     mpload -bench -format json
+//
+// With -bench the command runs the benchmarks of the core 
+// components from the mpservertest package instead.
+package main
+
+import (
+    "context"
+    "encoding/csv"
+    "encoding/json"
+    "errors"
+    "flag"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "os"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+    "mpserver/mpservertest"
+)
+
+var (
+    urls = flag.String("url", "http://localhost:8080/", 
+        "comma separated URLs to send requests to")
+    rates = flag.String("rate", "100", 
+        "comma separated request rates per second to run at")
+    duration = flag.Duration("duration", 10*time.Second, 
+        "duration of the run for each URL and rate")
+    timeout = flag.Duration("timeout", time.Second, 
+        "timeout of a single request")
+    keepAlive = flag.Bool("keepalive", false, 
+        "reuse connections, by default every request uses a new one")
+    format = flag.String("format", "csv", "output format: csv or json")
+    output = flag.String("out", "", "output file, stdout by default")
+    bench = flag.Bool("bench", false, 
+        "run the component benchmarks instead of generating load")
+)
+
+// Result holds the statistics of a run against one URL at one 
+// rate. Latencies are in milliseconds and cover all requests.
+type Result struct {
+    URL string          `json:"url"`
+    Rate float64        `json:"rate"`
+    Duration float64    `json:"duration_s"`
+    Sent int            `json:"sent"`
+    Succeeded int       `json:"succeeded"`
+    Failed int          `json:"failed"`
+    TimedOut int        `json:"timed_out"`
+    Throughput float64  `json:"throughput"`
+    Mean float64        `json:"mean_ms"`
+    P50 float64         `json:"p50_ms"`
+    P90 float64         `json:"p90_ms"`
+    P99 float64         `json:"p99_ms"`
+    P999 float64        `json:"p999_ms"`
+    Max float64         `json:"max_ms"`
+}
+
+// BenchResult holds the outcome of a component benchmark.
+type BenchResult struct {
+    Name string         `json:"name"`
+    N int               `json:"n"`
+    NsPerOp int64       `json:"ns_per_op"`
+    AllocsPerOp int64   `json:"allocs_per_op"`
+    BytesPerOp int64    `json:"bytes_per_op"`
+}
+
+// outcome of a single request.
+type outcome int
+
+const (
+    succeeded outcome = iota
+    failed
+    timedOut
+)
+
+// recorder collects the outcomes and latencies of requests.
+type recorder struct {
+    lock sync.Mutex
+    latencies []time.Duration // Of all requests.
+    outcomes [3]int
+}
+
+// record records the outcome and latency of a request. Timed out
+// requests count at least the timeout, so that they can't lower
+// the tail of the latencies.
+func (r *recorder) record(o outcome, latency time.Duration) {
+    r.lock.Lock()
+    defer r.lock.Unlock()
+    r.outcomes[o]++
+    if (o == timedOut && latency < *timeout) {
+        latency = *timeout
+    }
+    r.latencies = append(r.latencies, latency)
+}
+
+// send sends one request to the url and records its outcome. The
+// latency is measured from the intended time of the request.
+func send(client *http.Client, url string, intended time.Time, 
+          rec *recorder) {
+    ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+    defer cancel()
+    req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+    if (err != nil) {
+        rec.record(failed, time.Since(intended))
+        return
+    }
+    resp, err := client.Do(req)
+    if (err == nil) {
+        _, err = io.Copy(io.Discard, resp.Body)
+        resp.Body.Close()
+    }
+    latency := time.Since(intended)
+    switch {
+        case errors.Is(err, context.DeadlineExceeded): {
+            rec.record(timedOut, latency)
+        }
+        case err != nil || resp.StatusCode >= 400: {
+            rec.record(failed, latency)
+        }
+        default: rec.record(succeeded, latency)
+    }
+}
+
+// run sends requests to the url at the provided rate for the 
+// duration and returns the statistics of the run.
+func run(client *http.Client, url string, rate float64) Result {
+    interval := time.Duration(float64(time.Second) / rate)
+    rec := &recorder{}
+    var wg sync.WaitGroup
+    start := time.Now()
+    sent := 0
+    for {
+        intended := start.Add(time.Duration(sent) * interval)
+        if (intended.Sub(start) >= *duration) {
+            break
+        }
+        time.Sleep(time.Until(intended))
+        wg.Add(1)
+        go func () {
+            defer wg.Done()
+            send(client, url, intended, rec)
+        }()
+        sent++
+    }
+    wg.Wait()
+    elapsed := time.Since(start)
+
+    res := Result{
+        URL: url, Rate: rate, Duration: elapsed.Seconds(), 
+        Sent: sent, Succeeded: rec.outcomes[succeeded],
+        Failed: rec.outcomes[failed], 
+        TimedOut: rec.outcomes[timedOut],
+        Throughput: float64(rec.outcomes[succeeded]) / 
+            elapsed.Seconds(),
+    }
+    latencies := rec.latencies
+    if (len(latencies) == 0) {
+        return res
+    }
+    sort.Slice(latencies, func (i, j int) bool {
+        return latencies[i] < latencies[j]
+    })
+    var total time.Duration
+    for _, l := range latencies {
+        total += l
+    }
+    res.Mean = millis(total / time.Duration(len(latencies)))
+    res.P50 = millis(percentile(latencies, 0.5))
+    res.P90 = millis(percentile(latencies, 0.9))
+    res.P99 = millis(percentile(latencies, 0.99))
+    res.P999 = millis(percentile(latencies, 0.999))
+    res.Max = millis(latencies[len(latencies)-1])
+    return res
+}
+
+// percentile returns the p-th percentile of the sorted latencies
+// using the nearest rank method.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+    rank := int(p * float64(len(sorted)) + 0.5)
+    if (rank < 1) {
+        rank = 1
+    }
+    if (rank > len(sorted)) {
+        rank = len(sorted)
+    }
+    return sorted[rank-1]
+}
+
+func millis(d time.Duration) float64 {
+    return float64(d) / float64(time.Millisecond)
+}
+
+// parseRates parses the comma separated list of rates.
+func parseRates(list string) ([]float64, error) {
+    var res []float64
+    for _, s := range strings.Split(list, ",") {
+        rate, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+        if (err != nil || rate <= 0) {
+            return nil, fmt.Errorf("Invalid rate %q.", s)
+        }
+        res = append(res, rate)
+    }
+    return res, nil
+}
+
+// writeCSV writes the header and the records as CSV.
+func writeCSV(w io.Writer, header []string, records [][]string) error {
+    cw := csv.NewWriter(w)
+    cw.Write(header)
+    cw.WriteAll(records)
+    return cw.Error()
+}
+
+func formatFloat(f float64) string {
+    return strconv.FormatFloat(f, 'f', 3, 64)
+}
+
+// writeResults writes the results in the selected format.
+func writeResults(w io.Writer, results []Result) error {
+    if (*format == "json") {
+        enc := json.NewEncoder(w)
+        enc.SetIndent("", "  ")
+        return enc.Encode(results)
+    }
+    records := make([][]string, len(results))
+    for i, r := range results {
+        records[i] = []string{
+            r.URL, formatFloat(r.Rate), formatFloat(r.Duration),
+            strconv.Itoa(r.Sent), strconv.Itoa(r.Succeeded), 
+            strconv.Itoa(r.Failed), strconv.Itoa(r.TimedOut), 
+            formatFloat(r.Throughput), formatFloat(r.Mean), 
+            formatFloat(r.P50), formatFloat(r.P90), 
+            formatFloat(r.P99), formatFloat(r.P999), 
+            formatFloat(r.Max),
+        }
+    }
+    return writeCSV(w, []string{"url", "rate", "duration_s", "sent",
+        "succeeded", "failed", "timed_out", "throughput", "mean_ms",
+        "p50_ms", "p90_ms", "p99_ms", "p999_ms", "max_ms"}, records)
+}
+
+// writeBenchResults writes the benchmark results in the selected
+// format.
+func writeBenchResults(w io.Writer, results []BenchResult) error {
+    if (*format == "json") {
+        enc := json.NewEncoder(w)
+        enc.SetIndent("", "  ")
+        return enc.Encode(results)
+    }
+    records := make([][]string, len(results))
+    for i, r := range results {
+        records[i] = []string{r.Name, strconv.Itoa(r.N), 
+            strconv.FormatInt(r.NsPerOp, 10), 
+            strconv.FormatInt(r.AllocsPerOp, 10),
+            strconv.FormatInt(r.BytesPerOp, 10)}
+    }
+    return writeCSV(w, []string{"name", "n", "ns_per_op", 
+        "allocs_per_op", "bytes_per_op"}, records)
+}
+
+// runBenchmarks runs the benchmarks of the core components.
+func runBenchmarks() []BenchResult {
+    var results []BenchResult
+    for _, b := range mpservertest.CoreBenchmarks() {
+        r := testing.Benchmark(b.F)
+        log.Printf("%s\t%s", b.Name, r)
+        results = append(results, BenchResult{
+            Name: b.Name, N: r.N, NsPerOp: r.NsPerOp(), 
+            AllocsPerOp: r.AllocsPerOp(), 
+            BytesPerOp: r.AllocedBytesPerOp(),
+        })
+    }
+    return results
+}
+
+func main() {
+    flag.Parse()
+    if (*format != "csv" && *format != "json") {
+        log.Fatalf("Unknown format %q.", *format)
+    }
+    w := io.Writer(os.Stdout)
+    if (*output != "") {
+        file, err := os.Create(*output)
+        if (err != nil) {
+            log.Fatal(err)
+        }
+        defer file.Close()
+        w = file
+    }
+
+    var err error
+    if (*bench) {
+        err = writeBenchResults(w, runBenchmarks())
+    } else {
+        rateList, rateErr := parseRates(*rates)
+        if (rateErr != nil) {
+            log.Fatal(rateErr)
+        }
+        client := &http.Client{Transport: &http.Transport{
+            DisableKeepAlives: !*keepAlive,
+            MaxIdleConnsPerHost: 1024,
+        }}
+        var results []Result
+        for _, url := range strings.Split(*urls, ",") {
+            for _, rate := range rateList {
+                log.Printf("Sending %v requests/s to %s for %v.", 
+                    rate, url, *duration)
+                res := run(client, strings.TrimSpace(url), rate)
+                log.Printf("%d sent, %d succeeded, p99 %.3fms.", 
+                    res.Sent, res.Succeeded, res.P99)
+                results = append(results, res)
+            }
+        }
+        err = writeResults(w, results)
+    }
+    if (err != nil) {
+        log.Fatal(err)
+    }
+}
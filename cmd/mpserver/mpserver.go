@@ -0,0 +1,192 @@
+// Command mpserver is a web-server built entirely from the 
+// components of the mpserver library. It can serve files from a
+// directory, act as a caching reverse proxy or run the pipelines
+// described by a configuration file.
+//
+// Usage:
+//
+//     mpserver -dir ./public -balance dynamic -workers 8
+//     mpserver -proxy example.com -cache 1m -proxy-path /api/
+//     mpserver -config server.json
+package main
+
+import (
+    "context"
+    "errors"
+    "flag"
+    "log"
+    "net/http"
+    "os"
+    "os/signal"
+    "strings"
+    "syscall"
+    "time"
+    "mpserver"
+)
+
+var (
+    addr = flag.String("addr", ":8080", 
+        "address to listen on, overrides the address in -config")
+    config = flag.String("config", "", 
+        "JSON configuration file, other flags apart from -addr "+
+        "and -shutdown-timeout are ignored if it is set")
+
+    dir = flag.String("dir", "", "directory to serve files from")
+    prefix = flag.String("prefix", "/", "URL path to serve files at")
+
+    proxy = flag.String("proxy", "", "upstream host to proxy requests to")
+    scheme = flag.String("scheme", "http", "scheme of the upstream host")
+    proxyPath = flag.String("proxy-path", "/", 
+        "URL path to proxy requests from")
+    cache = flag.Duration("cache", 0, 
+        "expiration of cached upstream responses, 0 disables caching")
+    timeout = flag.Duration("timeout", 30*time.Second, 
+        "timeout of upstream requests")
+
+    balance = flag.String("balance", "simple", 
+        "load balancing: simple, static or dynamic")
+    workers = flag.Int("workers", 4, 
+        "number of workers for static balancing and maximum for dynamic")
+    shutdownTimeout = flag.Duration("shutdown-timeout", 30*time.Second,
+        "maximum time to wait for in-flight requests on shutdown")
+)
+
+// balanceWriter wraps the writer in the load balancer selected 
+// by the -balance flag.
+func balanceWriter(writer mpserver.Writer) mpserver.Writer {
+    switch *balance {
+        case "static": {
+            return mpserver.StaticLoadBalancerWriter(writer, *workers)
+        }
+        case "dynamic": {
+            return mpserver.DynamicLoadBalancerWriter(
+                writer, *workers, time.Millisecond, time.Minute)
+        }
+    }
+    return writer
+}
+
+// balanceComponent wraps the component in the load balancer 
+// selected by the -balance flag.
+func balanceComponent(c mpserver.Component) mpserver.Component {
+    switch *balance {
+        case "static": {
+            return mpserver.StaticLoadBalancer(c, *workers)
+        }
+        case "dynamic": {
+            return mpserver.DynamicLoadBalancer(
+                c, *workers, time.Millisecond, time.Minute)
+        }
+    }
+    return c
+}
+
+// subtree returns the ServeMux pattern for the provided path and
+// the prefix that has to be stripped from request paths.
+func subtree(path string) (string, string) {
+    strip := strings.TrimSuffix(path, "/")
+    return strip + "/", strip
+}
+
+// proxyWriter returns a writer that forwards requests to the 
+// upstream host, caching the responses if caching is enabled. 
+// The load balancer runs several cache workers that share the 
+// storage, so that a slow upstream request doesn't hold up the 
+// requests answered from the cache.
+func proxyWriter(storage mpserver.Storage) mpserver.Writer {
+    client := &http.Client{Timeout: *timeout}
+    upstream := mpserver.ProxyComponent(*scheme, *proxy, client)
+    if (storage != nil) {
+        upstream = mpserver.CacheComponent(storage, upstream, *cache)
+    }
+    return mpserver.SplitErrors(
+        []mpserver.Component{balanceComponent(upstream)}, 
+        mpserver.ResponseWriter, mpserver.ErrorWriter)
+}
+
+// flagSet reports whether the flag with the provided name was 
+// set on the command line.
+func flagSet(name string) bool {
+    set := false
+    flag.Visit(func (f *flag.Flag) {
+        if (f.Name == name) {
+            set = true
+        }
+    })
+    return set
+}
+
+// newServer builds the server described by the flags.
+func newServer() (*mpserver.Server, error) {
+    if (*config != "") {
+        cfg, err := mpserver.LoadConfig(*config)
+        if (err != nil) {
+            return nil, err
+        }
+        // The address of the configuration is overridden only 
+        // by an explicit -addr flag.
+        if (cfg.Addr == "" || flagSet("addr")) {
+            cfg.Addr = *addr
+        }
+        return mpserver.DefaultRegistry.NewServer(cfg)
+    }
+
+    if (*dir == "" && *proxy == "") {
+        return nil, errors.New("One of -dir, -proxy or -config is required.")
+    }
+    if (*balance != "simple" && *balance != "static" && 
+        *balance != "dynamic") {
+        return nil, errors.New(
+            "Allowed values for -balance are simple, static and dynamic.")
+    }
+    filePattern, fileStrip := subtree(*prefix)
+    proxyPattern, _ := subtree(*proxyPath)
+    if (*dir != "" && *proxy != "" && filePattern == proxyPattern) {
+        return nil, errors.New(
+            "-prefix and -proxy-path must differ when serving both.")
+    }
+
+    s := mpserver.NewServer(*addr)
+    if (*dir != "") {
+        s.Route(filePattern, balanceWriter(
+            mpserver.FileServerWriter(*dir, fileStrip)))
+    }
+    if (*proxy != "") {
+        var storage mpserver.Storage
+        if (*cache > 0) {
+            // The cleaner runs until the process exits.
+            storage = mpserver.NewMemStorage()
+            go mpserver.StorageCleaner(storage, nil, *cache)
+        }
+        s.Route(proxyPattern, proxyWriter(storage))
+    }
+    return s, nil
+}
+
+func main() {
+    flag.Parse()
+    s, err := newServer()
+    if (err != nil) {
+        log.Fatal(err)
+    }
+
+    // Shut down gracefully on interrupt.
+    stopped := make(chan bool)
+    go func () {
+        signals := make(chan os.Signal, 1)
+        signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+        <- signals
+        ctx, cancel := context.WithTimeout(
+            context.Background(), *shutdownTimeout)
+        defer cancel()
+        if err := s.Shutdown(ctx); err != nil {
+            log.Println("Shutdown:", err)
+        }
+        close(stopped)
+    }()
+
+    if err := s.ListenAndServe(); err != http.ErrServerClosed {
+        log.Fatal(err)
+    }
+    <- stopped
+}
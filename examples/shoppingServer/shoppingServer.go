@@ -34,17 +34,9 @@ func actionWriter(actionMaker mpserver.Component,
     }
 }
 
-func main() {
-    // Make channels
-    toAddActionWriter := mpserver.GetChan()
-    toRmvActionWriter := mpserver.GetChan()
-    toBuyActionWriter := mpserver.GetChan()
-
-    // Set up listeners
-    mpserver.Listen("/add", toAddActionWriter, nil)
-    mpserver.Listen("/remove", toRmvActionWriter, nil)
-    mpserver.Listen("/buy", toBuyActionWriter, nil)
-
+// shoppingModule returns a module with the add, remove and buy 
+// routes, whose writers share one storage of shopping carts.
+func shoppingModule() *mpserver.Module {
     // Create the storage
     storage := mpserver.NewMemStorage()
 
@@ -60,13 +52,21 @@ func main() {
         rmvActionWriter, 20, AddTimeout, RemoveTimeout)
     buyActionWriter = mpserver.DynamicLoadBalancerWriter(
         buyActionWriter, 40, AddTimeout, RemoveTimeout)
-    
-    // Start the load balanced writers and storage cleaner
-    go addActionWriter(toAddActionWriter)
-    go rmvActionWriter(toRmvActionWriter)
-    go buyActionWriter(toBuyActionWriter)
-    go mpserver.StorageCleaner(storage, nil, SessionExpiration)
+
+    return mpserver.NewModule("shopping").
+        Route("/add", addActionWriter).
+        Route("/remove", rmvActionWriter).
+        Route("/buy", buyActionWriter).
+        Background(func (shutDown <-chan bool) {
+            mpserver.StorageCleaner(
+                storage, shutDown, SessionExpiration)
+        })
+}
+
+func main() {
+    // Mount the shopping module at the root
+    shoppingModule().Mount("/", nil)
 
     // Start the server
     mpserver.ListenAndServe(":3000", nil)
-}
\ No newline at end of file
+}
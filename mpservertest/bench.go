@@ -0,0 +1,151 @@
+package mpservertest
+
+import (
+    "testing"
+    "time"
+    "mpserver"
+)
+
+// NewGetJob returns a job for a GET request of the root path. It
+// is the default job generator of the benchmarks.
+func NewGetJob() mpserver.Job {
+    job, _ := NewRequestJob("GET", "/", nil)
+    return job
+}
+
+// newJobs returns n jobs created by newJob, or by NewGetJob if 
+// newJob is nil.
+func newJobs(n int, newJob func () mpserver.Job) []mpserver.Job {
+    if (newJob == nil) {
+        newJob = NewGetJob
+    }
+    jobs := make([]mpserver.Job, n)
+    for i := range jobs {
+        jobs[i] = newJob()
+    }
+    return jobs
+}
+
+// BenchmarkComponent measures the throughput of the component. It
+// sends b.N jobs created by newJob to the component and waits 
+// until the component outputs all of them and closes its output
+// channel. If newJob is nil, NewGetJob is used. The jobs are 
+// created before the timer starts, so only the component is 
+// measured.
+func BenchmarkComponent(b *testing.B, c mpserver.Component, 
+                        newJob func () mpserver.Job) {
+    jobs := newJobs(b.N, newJob)
+    in := mpserver.GetChan()
+    out := mpserver.GetChan()
+    go c(in, out)
+
+    received := make(chan int)
+    go func () {
+        n := 0
+        for _ = range out {
+            n++
+        }
+        received <- n
+    }()
+
+    b.ReportAllocs()
+    b.ResetTimer()
+    for _, job := range jobs {
+        in <- job
+    }
+    close(in)
+    if n := <- received; n != b.N {
+        b.Fatalf("Component output %d of %d jobs.", n, b.N)
+    }
+}
+
+// BenchmarkWriter measures the throughput of the writer. It sends 
+// b.N jobs created by newJob to the writer and waits until the 
+// writer terminates. If newJob is nil, NewGetJob is used. As in 
+// BenchmarkComponent, the jobs are created before the timer 
+// starts.
+func BenchmarkWriter(b *testing.B, w mpserver.Writer, 
+                     newJob func () mpserver.Job) {
+    jobs := newJobs(b.N, newJob)
+    in := mpserver.GetChan()
+    terminated := make(chan bool)
+    go func () {
+        w(in)
+        close(terminated)
+    }()
+
+    b.ReportAllocs()
+    b.ResetTimer()
+    for _, job := range jobs {
+        in <- job
+    }
+    close(in)
+    <- terminated
+}
+
+// Benchmark is a named benchmark that can be run by 
+// testing.Benchmark or b.Run.
+type Benchmark struct {
+    Name string
+    F func (b *testing.B)
+}
+
+// Identity is a component that outputs its input jobs unchanged.
+// Wrapped in other components, it lets benchmarks and tests 
+// measure the overhead of the wrapping component alone.
+var Identity = mpserver.MakeComponent(func (job mpserver.Job) {})
+
+// NewResultJob returns a job generator for the benchmarks that 
+// creates GET jobs with the provided result.
+func NewResultJob(result interface{}) func () mpserver.Job {
+    return func () mpserver.Job {
+        job := NewGetJob()
+        job.SetResult(result)
+        return job
+    }
+}
+
+// componentBenchmark returns a Benchmark of the component that 
+// uses the default jobs.
+func componentBenchmark(name string, c mpserver.Component) Benchmark {
+    return Benchmark{name, func (b *testing.B) {
+        BenchmarkComponent(b, c, nil)
+    }}
+}
+
+// writerBenchmark returns a Benchmark of the writer that uses jobs
+// with the provided result.
+func writerBenchmark(name string, w mpserver.Writer, 
+                     result interface{}) Benchmark {
+    return Benchmark{name, func (b *testing.B) {
+        BenchmarkWriter(b, w, NewResultJob(result))
+    }}
+}
+
+// CoreBenchmarks returns benchmarks of the core components and 
+// writers of the mpserver package. Each benchmark wraps a trivial
+// worker, so that it measures the overhead of the component 
+// itself.
+func CoreBenchmarks() []Benchmark {
+    return []Benchmark{
+        componentBenchmark("MakeComponent", Identity),
+        componentBenchmark("LinkComponents", 
+            mpserver.LinkComponents(Identity, Identity, Identity)),
+        componentBenchmark("ErrorPasser", 
+            mpserver.ErrorPasser(Identity)),
+        componentBenchmark("PannicHandler", 
+            mpserver.PannicHandler(Identity)),
+        componentBenchmark("StaticLoadBalancer", 
+            mpserver.StaticLoadBalancer(Identity, 4)),
+        componentBenchmark("DynamicLoadBalancer", 
+            mpserver.DynamicLoadBalancer(
+                Identity, 4, time.Millisecond, time.Second)),
+        componentBenchmark("CacheComponent", 
+            mpserver.CacheComponent(
+                mpserver.NewMemStorage(), Identity, time.Minute)),
+        writerBenchmark("StringWriter", 
+            mpserver.StringWriter, "Hello world!"),
+        writerBenchmark("JsonWriter", 
+            mpserver.JsonWriter, map[string]int{"value": 1}),
+    }
+}
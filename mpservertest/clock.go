@@ -0,0 +1,123 @@
+package mpservertest
+
+import (
+    "sync"
+    "time"
+    "mpserver"
+)
+
+// FakeClock is a mpserver.Clock whose time only moves when it is 
+// advanced, which makes testing of time dependent components 
+// deterministic. The zero value is not usable, use NewFakeClock.
+type FakeClock struct {
+    lock sync.Mutex
+    now time.Time
+    waiters []*fakeWaiter
+}
+
+// fakeWaiter is a channel waiting for the clock to reach a time.
+type fakeWaiter struct {
+    deadline time.Time
+    ch chan time.Time
+}
+
+// NewFakeClock returns a FakeClock set to the provided time.
+func NewFakeClock(now time.Time) *FakeClock {
+    return &FakeClock{now: now}
+}
+
+// Now returns the current time of the clock.
+func (c *FakeClock) Now() time.Time {
+    c.lock.Lock()
+    defer c.lock.Unlock()
+    return c.now
+}
+
+// After returns a channel that receives the time of the clock 
+// once the clock is advanced by at least d. The wait counts as a
+// waiter until then, use NewTimer for waits that may be given up.
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+    return c.NewTimer(d).C()
+}
+
+// NewTimer returns a timer that fires once the clock is advanced
+// by at least d. A stopped timer no longer counts as a waiter.
+func (c *FakeClock) NewTimer(d time.Duration) mpserver.Timer {
+    c.lock.Lock()
+    defer c.lock.Unlock()
+    w := &fakeWaiter{c.now.Add(d), make(chan time.Time, 1)}
+    if (d <= 0) {
+        w.ch <- c.now
+    } else {
+        c.waiters = append(c.waiters, w)
+    }
+    return fakeTimer{c, w}
+}
+
+// fakeTimer is the Timer of a FakeClock.
+type fakeTimer struct {
+    clock *FakeClock
+    waiter *fakeWaiter
+}
+
+func (t fakeTimer) C() <-chan time.Time {
+    return t.waiter.ch
+}
+
+func (t fakeTimer) Stop() bool {
+    c := t.clock
+    c.lock.Lock()
+    defer c.lock.Unlock()
+    for i, w := range c.waiters {
+        if (w == t.waiter) {
+            c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+            return true
+        }
+    }
+    return false
+}
+
+// Sleep blocks until the clock is advanced by at least d.
+func (c *FakeClock) Sleep(d time.Duration) {
+    <- c.After(d)
+}
+
+// Advance moves the clock forward by d and fires all After and 
+// Sleep calls and timers whose duration has elapsed.
+func (c *FakeClock) Advance(d time.Duration) {
+    c.lock.Lock()
+    defer c.lock.Unlock()
+    c.now = c.now.Add(d)
+    waiting := c.waiters[:0]
+    for _, w := range c.waiters {
+        if (w.deadline.After(c.now)) {
+            waiting = append(waiting, w)
+        } else {
+            w.ch <- c.now
+        }
+    }
+    c.waiters = waiting
+}
+
+// Waiters returns the number of After and Sleep calls and timers
+// waiting for the clock to be advanced.
+func (c *FakeClock) Waiters() int {
+    c.lock.Lock()
+    defer c.lock.Unlock()
+    return len(c.waiters)
+}
+
+// BlockUntil blocks until at least n After or Sleep calls or 
+// timers are waiting for the clock, or the timeout expires. It returns false
+// in the latter case. It is used to make sure that a component 
+// is waiting before the clock is advanced.
+func (c *FakeClock) BlockUntil(n int, timeout time.Duration) bool {
+    deadline := time.Now().Add(timeout)
+    for c.Waiters() < n {
+        if (time.Now().After(deadline)) {
+            return false
+        }
+        time.Sleep(time.Millisecond)
+    }
+    return true
+}
@@ -0,0 +1,38 @@
+package mpservertest
+
+import (
+    "testing"
+    "time"
+)
+
+func TestFakeClockTimer(t *testing.T) {
+    clock := NewFakeClock(time.Unix(0, 0))
+    fired := clock.NewTimer(time.Second)
+    stopped := clock.NewTimer(time.Second)
+    if (clock.Waiters() != 2) {
+        t.Fatalf("%d waiters, want 2.", clock.Waiters())
+    }
+    if (!stopped.Stop()) {
+        t.Error("Stop of a pending timer returned false.")
+    }
+    if (clock.Waiters() != 1) {
+        t.Errorf("%d waiters after Stop, want 1.", clock.Waiters())
+    }
+
+    clock.Advance(time.Second)
+    select {
+        case now := <- fired.C(): {
+            if (!now.Equal(time.Unix(1, 0))) {
+                t.Errorf("Timer fired at %v.", now)
+            }
+        }
+        default: t.Error("Timer didn't fire.")
+    }
+    select {
+        case <- stopped.C(): t.Error("Stopped timer fired.")
+        default: {}
+    }
+    if (fired.Stop()) {
+        t.Error("Stop of a fired timer returned true.")
+    }
+}
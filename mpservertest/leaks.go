@@ -0,0 +1,95 @@
+package mpservertest
+
+import (
+    "bytes"
+    "errors"
+    "runtime"
+    "strconv"
+    "strings"
+    "time"
+    "mpserver"
+)
+
+// goroutines returns the stack traces of all current goroutines,
+// indexed by their ids.
+func goroutines() map[int]string {
+    buf := make([]byte, 1 << 16)
+    for {
+        n := runtime.Stack(buf, true)
+        if (n < len(buf)) {
+            buf = buf[:n]
+            break
+        }
+        buf = make([]byte, 2 * len(buf))
+    }
+
+    res := make(map[int]string)
+    for _, trace := range bytes.Split(buf, []byte("\n\n")) {
+        // A trace starts with "goroutine <id> [<state>]:".
+        fields := strings.Fields(string(trace))
+        if (len(fields) < 2 || fields[0] != "goroutine") {
+            continue
+        }
+        id, err := strconv.Atoi(fields[1])
+        if (err == nil) {
+            res[id] = string(trace)
+        }
+    }
+    return res
+}
+
+// CheckNoLeaks runs the provided function and checks that every 
+// goroutine it started has exited within the timeout after it 
+// returned. The function should close the input channels of the
+// pipelines it runs and wait for their outputs. An error is 
+// returned if the function fails or some goroutines are still 
+// running, in which case the error contains their stack traces.
+// Goroutines started concurrently by other code, such as other 
+// tests running in parallel, are reported as well.
+func CheckNoLeaks(timeout time.Duration, run func () error) error {
+    before := goroutines()
+    if err := run(); err != nil {
+        return err
+    }
+
+    deadline := time.Now().Add(timeout)
+    for {
+        var leaked []string
+        for id, trace := range goroutines() {
+            if _, ok := before[id]; !ok {
+                leaked = append(leaked, trace)
+            }
+        }
+        if (len(leaked) == 0) {
+            return nil
+        }
+        if (time.Now().After(deadline)) {
+            return errors.New(strconv.Itoa(len(leaked)) + 
+                " goroutines are still running:\n\n" + 
+                strings.Join(leaked, "\n\n"))
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+}
+
+// CheckComponentShutdown runs the component with the provided 
+// jobs as its input, as RunComponent does, and checks that all 
+// goroutines that the component started have exited after it 
+// closed its output channel.
+func CheckComponentShutdown(c mpserver.Component, 
+                            jobs ...mpserver.Job) error {
+    return CheckNoLeaks(DefaultTimeout, func () error {
+        _, err := RunComponent(c, jobs...)
+        return err
+    })
+}
+
+// CheckWriterShutdown runs the writer with the provided jobs as 
+// its input, as RunWriter does, and checks that all goroutines 
+// that the writer started have exited after it terminated.
+func CheckWriterShutdown(w mpserver.Writer, 
+                         jobs ...mpserver.Job) error {
+    return CheckNoLeaks(DefaultTimeout, func () error {
+        return RunWriter(w, jobs...)
+    })
+}
@@ -0,0 +1,30 @@
+package mpservertest
+
+import (
+    "testing"
+    "time"
+    "mpserver"
+)
+
+func TestCheckComponentShutdownLeak(t *testing.T) {
+    release := make(chan bool)
+    defer close(release)
+    // Leaves a goroutine running after it closes its output.
+    leaky := func (in <-chan mpserver.Job, out chan<- mpserver.Job) {
+        go func () {
+            <- release
+        }()
+        for job := range in {
+            out <- job
+        }
+        close(out)
+    }
+    old := DefaultTimeout
+    DefaultTimeout = 100 * time.Millisecond
+    defer func () {
+        DefaultTimeout = old
+    }()
+    if err := CheckComponentShutdown(leaky, NewGetJob()); err == nil {
+        t.Error("Leaked goroutine wasn't reported.")
+    }
+}
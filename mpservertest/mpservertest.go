@@ -0,0 +1,161 @@
+// Package mpservertest provides utilities for testing Components
+// and Writers of the mpserver package.
+package mpservertest
+
+import (
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "time"
+    "mpserver"
+)
+
+// DefaultTimeout is the time that RunComponent and RunWriter 
+// wait for Components and Writers to finish.
+var DefaultTimeout = 5 * time.Second
+
+// Recorder records the response written for a job. The status,
+// headers and body are available through the embedded 
+// httptest.ResponseRecorder.
+type Recorder struct {
+    *httptest.ResponseRecorder
+    job mpserver.Job
+}
+
+// Completed reports whether the job has been completed, that is
+// whether its Writer finished writing the response.
+func (r *Recorder) Completed() bool {
+    select {
+        case <- mpserver.Done(r.job): return true
+        default: return false
+    }
+}
+
+// Wait waits until the job is completed or the timeout expires.
+func (r *Recorder) Wait(timeout time.Duration) error {
+    select {
+        case <- mpserver.Done(r.job): return nil
+        case <- time.After(timeout): {
+            return errors.New("Job wasn't completed in time.")
+        }
+    }
+}
+
+// NewJob returns a job for the provided request and a Recorder 
+// that records the response written for it.
+func NewJob(r *http.Request) (mpserver.Job, *Recorder) {
+    rec := httptest.NewRecorder()
+    job := mpserver.NewJob(rec, r)
+    return job, &Recorder{rec, job}
+}
+
+// NewRequestJob returns a job for a request with the provided 
+// method, target and body, as created by httptest.NewRequest, and
+// a Recorder that records the response written for it.
+func NewRequestJob(method, target string, 
+                   body io.Reader) (mpserver.Job, *Recorder) {
+    return NewJob(httptest.NewRequest(method, target, body))
+}
+
+// RunComponent runs the component with the provided jobs as its
+// input and returns the jobs that it outputs. The input channel 
+// is closed after all jobs are sent. An error is returned if the
+// component doesn't close its output channel within the 
+// DefaultTimeout.
+func RunComponent(c mpserver.Component, 
+                  jobs ...mpserver.Job) ([]mpserver.Job, error) {
+    return RunComponentTimeout(c, DefaultTimeout, jobs...)
+}
+
+// RunComponentTimeout behaves like RunComponent, but waits for 
+// the provided timeout.
+func RunComponentTimeout(c mpserver.Component, timeout time.Duration,
+                         jobs ...mpserver.Job) ([]mpserver.Job, error) {
+    in := mpserver.GetChan()
+    out := mpserver.GetChan()
+    go c(in, out)
+
+    // Send the jobs from a separate goroutine, so that components
+    // which output jobs before reading the next one don't block.
+    sent := make(chan bool)
+    go func () {
+        defer close(sent)
+        for _, job := range jobs {
+            select {
+                case in <- job: {}
+                case <- time.After(timeout): return
+            }
+        }
+        close(in)
+    }()
+
+    deadline := time.After(timeout)
+    var res []mpserver.Job
+    for {
+        select {
+            case job, ok := <- out: {
+                if (!ok) {
+                    <- sent
+                    return res, nil
+                }
+                res = append(res, job)
+            }
+            case <- deadline: {
+                return res, fmt.Errorf("Component didn't close its "+
+                    "output channel within %v, got %d of %d jobs.",
+                    timeout, len(res), len(jobs))
+            }
+        }
+    }
+}
+
+// RunWriter runs the writer with the provided jobs as its input 
+// and waits until all jobs are completed and the writer 
+// terminates. An error is returned if that doesn't happen within
+// the DefaultTimeout.
+func RunWriter(w mpserver.Writer, jobs ...mpserver.Job) error {
+    return RunWriterTimeout(w, DefaultTimeout, jobs...)
+}
+
+// RunWriterTimeout behaves like RunWriter, but waits for the 
+// provided timeout.
+func RunWriterTimeout(w mpserver.Writer, timeout time.Duration,
+                      jobs ...mpserver.Job) error {
+    in := mpserver.GetChan()
+    terminated := make(chan bool)
+    go func () {
+        w(in)
+        close(terminated)
+    }()
+
+    deadline := time.After(timeout)
+    for i, job := range jobs {
+        select {
+            case in <- job: {}
+            case <- deadline: {
+                return fmt.Errorf(
+                    "Writer didn't read job %d within %v.", i, timeout)
+            }
+        }
+    }
+    close(in)
+
+    for i, job := range jobs {
+        select {
+            case <- mpserver.Done(job): {}
+            case <- deadline: {
+                return fmt.Errorf(
+                    "Job %d wasn't completed within %v.", i, timeout)
+            }
+        }
+    }
+    select {
+        case <- terminated: return nil
+        case <- deadline: {
+            return fmt.Errorf(
+                "Writer didn't terminate within %v.", timeout)
+        }
+    }
+}
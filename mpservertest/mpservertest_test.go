@@ -0,0 +1,36 @@
+package mpservertest
+
+import (
+    "testing"
+    "time"
+    "mpserver"
+)
+
+func TestRunComponentTimeout(t *testing.T) {
+    job, _ := NewRequestJob("GET", "/", nil)
+    // Drops its input jobs and never closes its output channel.
+    stuck := func (in <-chan mpserver.Job, out chan<- mpserver.Job) {
+        for _ = range in {}
+    }
+    if _, err := RunComponentTimeout(stuck, 
+                                     50 * time.Millisecond, job); err == nil {
+        t.Error("Stuck component wasn't reported.")
+    }
+}
+
+func TestRunWriterRecorder(t *testing.T) {
+    job, rec := NewRequestJob("GET", "/", nil)
+    job.SetResult("hello")
+    if (rec.Completed()) {
+        t.Fatal("Job is completed before it was written.")
+    }
+    if err := RunWriter(mpserver.StringWriter, job); err != nil {
+        t.Fatal(err)
+    }
+    if err := rec.Wait(time.Second); err != nil {
+        t.Fatal(err)
+    }
+    if (rec.Body.String() != "hello") {
+        t.Errorf("Body is %q, want hello.", rec.Body.String())
+    }
+}